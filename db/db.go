@@ -5,6 +5,8 @@ import (
     "github.com/redis/go-redis/v9"
 	"encoding/json"
 ///	"log"
+	"reflect"
+	"strconv"
 	"time"
 )
 
@@ -115,3 +117,119 @@ func Exists(key...string)(bool, error){
 		return false, nil
 	}
 }
+
+func SetWithExpire(key, value string, expire time.Duration) error{
+	return g_redis_cli.Set(ctx, key, value, expire).Err()
+}
+
+func SetStructWithExpire(key string, obj interface{}, expire time.Duration)(error){
+	str, err := json.Marshal(&obj)
+	if err != nil {
+		return err
+	}
+	return SetWithExpire(key, string(str), expire)
+}
+
+func Incr(key string)(int64, error){
+	return g_redis_cli.Incr(ctx, key).Result()
+}
+
+type ZMember struct{
+	Member string
+	Score float64
+}
+
+func ZIncrBy(key string, incr float64, member string)error{
+	return g_redis_cli.ZIncrBy(ctx, key, incr, member).Err()
+}
+
+// 按分数从高到低取[start, stop]
+func ZRevRangeWithScores(key string, start, stop int64)([]ZMember, error){
+	var members []ZMember
+	values, err := g_redis_cli.ZRevRangeWithScores(ctx, key, start, stop).Result()
+	if err != nil {
+		return members, err
+	}
+	for _, v := range values{
+		member, _ := v.Member.(string)
+		members = append(members, ZMember{Member: member, Score: v.Score})
+	}
+	return members, nil
+}
+
+// 把多个有序集合的分数相加写到dest
+func ZUnionStore(dest string, keys ...string)error{
+	return g_redis_cli.ZUnionStore(ctx, dest, &redis.ZStore{Keys: keys}).Err()
+}
+
+func PFAdd(key string, els ...interface{})error{
+	return g_redis_cli.PFAdd(ctx, key, els...).Err()
+}
+
+func PFCount(keys ...string)(int64, error){
+	return g_redis_cli.PFCount(ctx, keys...).Result()
+}
+
+// 写入stream，超过maxLen时近似裁剪旧数据
+func XAdd(stream string, maxLen int64, values map[string]interface{})error{
+	return g_redis_cli.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: maxLen,
+		Approx: true,
+		Values: values,
+	}).Err()
+}
+
+func TTL(key string)(time.Duration, error){
+	return g_redis_cli.TTL(ctx, key).Result()
+}
+
+// 滑动窗口计数，记录一次并返回窗口内的总次数
+func SlidingWindowAdd(key string, window time.Duration)(int64, error){
+	now := time.Now()
+	pipe := g_redis_cli.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "0", strconv.FormatInt(now.Add(-window).UnixMilli(), 10))
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: strconv.FormatInt(now.UnixNano(), 10)})
+	count := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// 乐观锁修改json结构: WATCH后读出到obj，fn修改obj，再在MULTI里写回
+// 期间有别的客户端改了key就重新读再改，key不存在时obj是零值
+func UpdateStruct(key string, obj interface{}, fn func()error)error{
+	update := func(tx *redis.Tx)error{
+		reflect.ValueOf(obj).Elem().Set(reflect.Zero(reflect.TypeOf(obj).Elem()))
+		str, err := tx.Get(ctx, key).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal([]byte(str), obj); err != nil {
+				return err
+			}
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner)error{
+			pipe.Set(ctx, key, string(data), 0)
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < 10; i++ {
+		err := g_redis_cli.Watch(ctx, update, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return redis.TxFailedErr
+}
@@ -18,6 +18,10 @@ import (
 	"zincsearch/db"
 	"sync"
 	"strconv"
+	"sort"
+	"context"
+	"errors"
+	"net/http"
 )
 
 var g_sBotKey = ""
@@ -29,6 +33,26 @@ var adminchatid = int64(0)
 var zincsearch_url = ""
 var zincsearch_user = ""
 var zincsearch_passwd = ""
+// 定时刷新频道人数和标题的索引，为空不刷新
+var g_sRefreshIndex = ""
+var g_refreshInterval = 24 * time.Hour
+// 刷新和检测失效链接时调用telegram接口的限流，每秒次数
+var g_refreshLimiter = lib.NewRateLimiter(5)
+// 刷新时发现频道不存在是否直接删除，否则等管理员确认
+var g_bRefreshDelete = false
+var g_refresh_mutex sync.Mutex
+// 检测失效链接的索引，为空时用refresh_index
+var g_sVerifyIndex = ""
+var g_verifyInterval = 6 * time.Hour
+// 连续失败几次后隐藏
+var g_iVerifyThreshold = int(3)
+// 管理员恢复的文档多久内不再检测
+var g_verifySkipTTL = 30 * 24 * time.Hour
+// 转发与你、飞机私聊的消息确认消息还在，转发后立即删除，0不检查消息
+var g_iVerifyProbeChat = int64(0)
+var g_verify_mutex sync.Mutex
+// 查重时不用来关联文档的用户名，逗号分隔，管理员和自己的频道默认已包含
+var g_mapDedupIgnore = map[string]bool{}
 
 
 const (
@@ -207,6 +231,46 @@ func InitConfig(){
 			zincsearch_passwd = line[idx + 1:]
 		}else if line[0:idx] == "search_url"{
 			zincsearch_url = line[idx + 1:]
+		}else if line[0:idx] == "admin_chat_id"{
+			if tmp, err := strconv.ParseInt(line[idx + 1:], 10, 64); err == nil{
+				adminchatid = tmp
+			}
+		}else if line[0:idx] == "refresh_index"{
+			g_sRefreshIndex = line[idx + 1:]
+		}else if line[0:idx] == "refresh_interval_h"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil{
+				g_refreshInterval = time.Duration(tmp) * time.Hour
+			}
+		}else if line[0:idx] == "refresh_qps"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil{
+				g_refreshLimiter = lib.NewRateLimiter(tmp)
+			}
+		}else if line[0:idx] == "refresh_delete"{
+			g_bRefreshDelete = line[idx + 1:] == "1"
+		}else if line[0:idx] == "verify_index"{
+			g_sVerifyIndex = line[idx + 1:]
+		}else if line[0:idx] == "verify_interval_h"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil{
+				g_verifyInterval = time.Duration(tmp) * time.Hour
+			}
+		}else if line[0:idx] == "verify_threshold"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil && tmp > 0{
+				g_iVerifyThreshold = tmp
+			}
+		}else if line[0:idx] == "verify_skip_days"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil && tmp > 0{
+				g_verifySkipTTL = time.Duration(tmp) * 24 * time.Hour
+			}
+		}else if line[0:idx] == "verify_probe_chat"{
+			if tmp, err := strconv.ParseInt(line[idx + 1:], 10, 64); err == nil{
+				g_iVerifyProbeChat = tmp
+			}
+		}else if line[0:idx] == "dedup_ignore"{
+			for _, v := range strings.Split(line[idx + 1:], ","){
+				if v = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(v), "@")); v != ""{
+					g_mapDedupIgnore[v] = true
+				}
+			}
 		}else if line[0:idx] == "bak_key"{
 			g_sBakKey = line[idx + 1:]
 		}else if line[0:idx] == "back_keys"{
@@ -255,120 +319,1142 @@ func main() {
 		timers: make(map[string]*time.Timer),
 	}
 
+	go runRefresher()
+	go runVerifier()
+
 	cur_cmd := ""
 	for update := range ch {
 		if update.ChannelPost != nil{
 			lib.XLogErr("skip post msg")
 			continue
 		}
+		if update.CallbackQuery != nil{
+			go handleCallback(update.CallbackQuery)
+			continue
+		}
 		if update.Message != nil{
 			if update.Message.Chat.Type != "private"{
 				lib.XLogErr("not private", update.Message.Chat.Type)
 				continue
 			}
-			// 非管理员发的反馈消息，如果是command直接执行，否则转发
-			if update.Message.From.UserName != adminuser{
-				lib.XLogErr("not admin", update.Message.From.UserName)
-				forwardMessage(update.Message)
+			// 非管理员发的反馈消息，如果是command直接执行，否则转发
+			if update.Message.From.UserName != adminuser{
+				lib.XLogErr("not admin", update.Message.From.UserName)
+				forwardMessage(update.Message)
+				continue
+			}
+			// 管理员回复的消息，转发给原始发消息的用户
+			if update.Message.ReplyToMessage != nil && update.Message.ReplyToMessage.ForwardFrom != nil{
+				forwardMessageToChat(update.Message, update.Message.ReplyToMessage.ForwardFrom.ID)
+				continue
+			}
+			if isCommand(update.Message.Text){
+				if update.Message.Text == "clear"{
+					cur_cmd = ""
+					lib.XLogInfo("clear command")
+				}else{
+					cur_cmd = update.Message.Text
+					lib.XLogInfo("change command", cur_cmd)
+				}
+				continue
+			}
+			if cur_cmd != ""{
+				// 处理媒体组消息
+				if (cur_cmd == "import_js"|| cur_cmd == "import_yunijs") && len(update.Message.MediaGroupID) > 0{
+					cache.handleMediaGroup(update.Message)
+				}else{
+					handleCommand(cur_cmd, update.Message)
+				}
+			}
+		}
+	}
+}
+
+func forwardMessageToChat(msg *model.Message, chatid int64){
+	config := model.SendMessageConfig{
+		ChatID: chatid,
+		Text: msg.Text,
+		Entities: msg.Entities,
+	}
+	tb.CallV2(&config)
+}
+
+func forwardMessage(msg *model.Message){
+	if strings.HasPrefix(msg.Text, "adddoc") || strings.HasPrefix(msg.Text, "/adddoc"){
+		submitDocument(msg)
+		return
+	}
+	if adminchatid == 0{
+		sendText(msg.Chat.ID, "消息发送失败，请稍后重试...")
+	}
+	config := model.ForwardMessageConfig{
+		ChatID: adminchatid,
+		FromChatID: msg.Chat.ID,
+		MessageID: msg.MessageID,
+	}
+	tb.CallV2(&config)
+}
+
+// 用户提交的频道先进审核队列，格式 adddoc 频道 名字 地区 标签1 标签2 ...
+func submitDocument(msg *model.Message){
+	items := strings.Fields(strings.TrimPrefix(strings.TrimPrefix(msg.Text, "/"), "adddoc"))
+	if len(items) < 3{
+		sendText(msg.Chat.ID, "操作失败，格式: /adddoc 频道用户名 名字 地区 标签1 标签2 ...")
+		return
+	}
+	user_name := strings.TrimPrefix(strings.TrimPrefix(items[0], "https://t.me/"), "@")
+	key := "zincsearch_bot_submission_" + user_name
+	if ok, _ := db.Exists(key); ok{
+		sendText(msg.Chat.ID, "这个频道已经提交过了，正在审核中")
+		return
+	}
+	exists, err := documentExists("search_qm", user_name)
+	if err != nil{
+		lib.XLogErr("documentExists", user_name, err)
+		sendText(msg.Chat.ID, "操作失败，请稍后重试")
+		return
+	}
+	if exists{
+		sendText(msg.Chat.ID, "这个频道已经收录了")
+		return
+	}
+	chatinfo_config := model.GetChatConfig{ChatID: "@" + user_name}
+	if err := tb.CallV2(&chatinfo_config); err != nil || chatinfo_config.Response.Type != "channel"{
+		lib.XLogErr("submit getchat", user_name, err)
+		sendText(msg.Chat.ID, "找不到这个频道，请确认是公开频道")
+		return
+	}
+	count, err := getChatMemberCount(user_name)
+	if err != nil{
+		lib.XLogErr("submit getchatmembercount", user_name, err)
+		sendText(msg.Chat.ID, "获取不到频道人数，请稍后重试")
+		return
+	}
+	submission := model.Submission{
+		ChatID: user_name,
+		Title: chatinfo_config.Response.Title,
+		UserCount: count,
+		JsName: items[1],
+		Location: items[2],
+		Tags: items[3:],
+		UserID: msg.Chat.ID,
+		CreatedAt: time.Now().Unix(),
+	}
+	if msg.From != nil{
+		submission.UserName = msg.From.UserName
+	}
+	if err := db.SetStruct(key, submission); err != nil{
+		lib.XLogErr("save submission", submission, err)
+		sendText(msg.Chat.ID, "操作失败，请稍后重试")
+		return
+	}
+	db.AddToSet("zincsearch_bot_submissions", user_name)
+	sendText(msg.Chat.ID, "已提交，审核通过后会收录")
+	if adminchatid != 0{
+		sendSubmission(adminchatid, submission)
+	}
+}
+
+// 索引里是否已经有这个文档
+func documentExists(index_name string, id string)(bool, error){
+	client := getZincClient()
+	index, err := client.ResolveIndex(index_name)
+	if err != nil{
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+	result, err := client.Query(ctx, index, &zincsearch.QueryRequest{
+		Query: zincsearch.Term("_id", id),
+		Size: 1,
+	})
+	if err != nil{
+		return false, err
+	}
+	return len(result.Hits.Hits) > 0, nil
+}
+
+func formatSubmission(submission model.Submission)string{
+	text := fmt.Sprintf("新提交的频道: %s @%s %d人\n名字: %s 地区: %s", submission.Title, submission.ChatID, submission.UserCount, submission.JsName, submission.Location)
+	if len(submission.Tags) > 0{
+		text += "\n标签: " + strings.Join(submission.Tags, " ")
+	}
+	text += fmt.Sprintf("\n提交人: %d @%s", submission.UserID, submission.UserName)
+	return text
+}
+
+func sendSubmission(chatid int64, submission model.Submission){
+	ok_data := "submit$$ok$$" + submission.ChatID
+	no_data := "submit$$no$$" + submission.ChatID
+	config := model.SendMessageConfig{ChatID: chatid, Text: formatSubmission(submission)}
+	config.ReplyMarkup = model.InlineKeyboardMarkup{InlineKeyboard: [][]model.InlineKeyboardButton{{
+		{Text: "✅ 通过", CallbackData: &ok_data},
+		{Text: "❌ 拒绝", CallbackData: &no_data},
+	}}}
+	tb.Call(&config)
+}
+
+func listSubmission(chatid int64)error{
+	names, err := db.GetSetMembers("zincsearch_bot_submissions")
+	if err != nil{
+		sendText(chatid, "操作失败")
+		return err
+	}
+	if len(names) == 0{
+		sendText(chatid, "没有待审核的频道")
+		return nil
+	}
+	for _, name := range names{
+		var submission model.Submission
+		if err := db.GetStruct("zincsearch_bot_submission_" + name, &submission); err != nil{
+			db.DelFromSet("zincsearch_bot_submissions", name)
+			continue
+		}
+		sendSubmission(chatid, submission)
+	}
+	return nil
+}
+
+// 审核通过后按原来adddoc的方式写入search_qm
+func reviewSubmission(callback *model.CallbackQuery, action string, user_name string){
+	key := "zincsearch_bot_submission_" + user_name
+	var submission model.Submission
+	if err := db.GetStruct(key, &submission); err != nil{
+		answerCallback(callback, "已经处理过了")
+		return
+	}
+	result := ""
+	if action == "ok"{
+		cmd := "search_qm " + submission.ChatID + " " + submission.JsName + " qm " + submission.Location + " " + strings.Join(submission.Tags, " ")
+		if err := insertDocument(callback.Message.Chat.ID, cmd); err != nil{
+			lib.XLogErr("approve submission", submission, err)
+			answerCallback(callback, "收录失败: " + err.Error())
+			return
+		}
+		result = "✅ 已通过"
+		sendText(submission.UserID, "你提交的频道 @" + submission.ChatID + " 已收录")
+	}else if action == "no"{
+		result = "❌ 已拒绝"
+		sendText(submission.UserID, "你提交的频道 @" + submission.ChatID + " 没有通过审核")
+	}else{
+		return
+	}
+	db.Del(key)
+	db.DelFromSet("zincsearch_bot_submissions", user_name)
+	finishReview(callback, result)
+}
+
+// 扫描索引找出疑似重复的文档，每组发给管理员选择合并或者保留
+func findDuplicates(chatid int64, name string){
+	defer func() {
+		if err := recover(); err != nil {
+			lib.XLogErr("excption", err)
+		}
+	}()
+	if name == ""{
+		name = g_sRefreshIndex
+	}
+	client := getZincClient()
+	index, err := client.ResolveIndex(name)
+	if err != nil{
+		lib.XLogErr("resolve index", name, err)
+		sendText(chatid, "扫描失败: " + err.Error())
+		return
+	}
+	keep, _ := db.GetSetMembers("zincsearch_bot_dedup_keep")
+	skip := make(map[string]bool, len(keep))
+	for _, id := range keep{
+		skip[id] = true
+	}
+	var docs []zincsearch.Document
+	err = client.Scan(index, 500, func(hits []zincsearch.Hit)error{
+		for _, hit := range hits{
+			if !skip[hit.ID]{
+				docs = append(docs, zincsearch.DocumentFromHit(hit))
+			}
+		}
+		return nil
+	})
+	if err != nil{
+		lib.XLogErr("scan duplicates", name, err)
+		sendText(chatid, "扫描失败: " + err.Error())
+		return
+	}
+	groups := zincsearch.GroupDuplicates(docs, dedupIgnoreHandles())
+	sendText(chatid, fmt.Sprintf("%s 共%d个文档，找到%d组疑似重复", name, len(docs), len(groups)))
+	const max_groups = 30
+	for i, group := range groups{
+		if i >= max_groups{
+			sendText(chatid, "只显示前" + strconv.Itoa(max_groups) + "组，处理完后再执行find_duplicates")
+			break
+		}
+		var ids []string
+		for _, doc := range group{
+			ids = append(ids, doc.ID)
+		}
+		primary := ids[0]
+		if err := db.SetStructWithExpire("zincsearch_bot_dup_group_" + primary, model.DuplicateGroup{Index: index, IDs: ids}, 7 * 24 * time.Hour); err != nil{
+			lib.XLogErr("save duplicate group", ids, err)
+			continue
+		}
+		text := "疑似重复:"
+		for _, doc := range group{
+			text += fmt.Sprintf("\n%s @%s %d人 %s %s %s", doc.Title, doc.ID, doc.UserCount, doc.JsName, doc.Location, doc.ContactType)
+		}
+		config := model.SendMessageConfig{ChatID: chatid, Text: text}
+		merge_data := "dup$$merge$$" + primary
+		keep_data := "dup$$keep$$" + primary
+		if len(merge_data) <= 64{
+			config.ReplyMarkup = model.InlineKeyboardMarkup{InlineKeyboard: [][]model.InlineKeyboardButton{{
+				{Text: "🔀 合并到 " + primary, CallbackData: &merge_data},
+				{Text: "✋ 不是重复", CallbackData: &keep_data},
+			}}}
+		}
+		tb.Call(&config)
+	}
+}
+
+// 标签合并去重，标签格式是 #a#b
+func mergeTags(tags ...string)string{
+	seen := make(map[string]bool)
+	result := ""
+	for _, v := range tags{
+		for _, tag := range strings.Split(v, "#"){
+			if tag = strings.TrimSpace(tag); tag != "" && !seen[tag]{
+				seen[tag] = true
+				result += "#" + tag
+			}
+		}
+	}
+	return result
+}
+
+// 管理员和几个自己的频道会出现在很多文档的描述里，不能用来判断重复
+func dedupIgnoreHandles()map[string]bool{
+	ignore := map[string]bool{
+		strings.ToLower(targetUserName): true,
+		strings.ToLower(shouluUserName): true,
+		strings.ToLower(reportGroupUserName): true,
+	}
+	if adminuser != ""{
+		ignore[strings.ToLower(strings.TrimPrefix(adminuser, "@"))] = true
+	}
+	for k := range g_mapDedupIgnore{
+		ignore[k] = true
+	}
+	return ignore
+}
+
+// 合并: 其他文档的标签并到主文档，然后删除其他文档；保留: 记到zincsearch_bot_dedup_keep，以后不再提示也不在搜索结果里合并
+func handleDuplicate(callback *model.CallbackQuery, action string, primary string){
+	key := "zincsearch_bot_dup_group_" + primary
+	var group model.DuplicateGroup
+	if err := db.GetStruct(key, &group); err != nil || len(group.IDs) == 0{
+		answerCallback(callback, "已经处理过或者已过期，请重新执行find_duplicates")
+		return
+	}
+	result := ""
+	if action == "merge"{
+		if err := mergeDuplicates(group); err != nil{
+			lib.XLogErr("mergeDuplicates", group, err)
+			answerCallback(callback, "合并失败: " + err.Error())
+			return
+		}
+		result = "🔀 已合并到 " + primary
+	}else if action == "keep"{
+		var ids []interface{}
+		for _, id := range group.IDs{
+			ids = append(ids, id)
+		}
+		if err := db.AddToSet("zincsearch_bot_dedup_keep", ids...); err != nil{
+			answerCallback(callback, "操作失败")
+			return
+		}
+		invalidateSearchCache(nil)
+		result = "✋ 已标记为不是重复"
+	}else{
+		return
+	}
+	db.Del(key)
+	finishReview(callback, result)
+}
+
+func mergeDuplicates(group model.DuplicateGroup)error{
+	client := getZincClient()
+	ids := make([]interface{}, 0, len(group.IDs))
+	for _, id := range group.IDs{
+		ids = append(ids, id)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+	rsp, err := client.Query(ctx, group.Index, &zincsearch.QueryRequest{
+		Query: zincsearch.Terms("_id", ids...),
+		Size: len(ids),
+	})
+	if err != nil{
+		return err
+	}
+	docs := make(map[string]zincsearch.Document)
+	for _, hit := range rsp.Hits.Hits{
+		docs[hit.ID] = zincsearch.DocumentFromHit(hit)
+	}
+	primary, ok := docs[group.IDs[0]]
+	if !ok{
+		return fmt.Errorf("主文档%s已经不存在", group.IDs[0])
+	}
+	// 只更新标签和创建时间，主文档的其它字段原样保留
+	fields := mergeFields(primary, group.IDs[1:], docs)
+	indexer := getZincIndexer()
+	if err := indexer.UpdateFields(group.Index, group.IDs[0], fields); err != nil{
+		return err
+	}
+	for _, id := range group.IDs[1:]{
+		if _, ok := docs[id]; !ok{
+			continue
+		}
+		if err := indexer.DeleteDocument(group.Index, id); err != nil{
+			return err
+		}
+	}
+	return nil
+}
+
+// 合并后主文档需要更新的字段：标签取并集，创建时间取最早的
+func mergeFields(primary zincsearch.Document, others []string, docs map[string]zincsearch.Document)map[string]interface{}{
+	tags := []string{primary.Tags}
+	created_at := primary.CreatedAt
+	for _, id := range others{
+		if doc, ok := docs[id]; ok{
+			tags = append(tags, doc.Tags)
+			if doc.CreatedAt > 0 && (created_at == 0 || doc.CreatedAt < created_at){
+				created_at = doc.CreatedAt
+			}
+		}
+	}
+	fields := map[string]interface{}{"tags": mergeTags(tags...)}
+	if created_at > 0{
+		fields["created_at"] = created_at
+	}
+	return fields
+}
+
+// 审核类的消息处理完后去掉按钮，在原消息后面加上处理结果
+func finishReview(callback *model.CallbackQuery, result string){
+	config := model.EditMessageTextConfig{
+		ChatID: callback.Message.Chat.ID,
+		MessageID: callback.Message.MessageID,
+		Text: callback.Message.Text + "\n" + result,
+	}
+	config.ReplyMarkup.InlineKeyboard = [][]model.InlineKeyboardButton{}
+	tb.Call(&config)
+	answerCallback(callback, result)
+}
+
+func isCommand(text string)bool{
+	cmds := []string{"get_js_report", "import_yunijs", "import_index", "report_index", "report_detail", "import_report", "clear_jsindex", "show_jsdetail", "list_jsindex", "import_js", "create_index", "list_index", "delete_index", "insert_document", "clear", "delete_document", "add_adfeed", "list_adfeed", "delete_adfeed", "add_topfeed", "list_topfeed", "delete_topfeed", "get_chatid", "show_mapping", "diff_mapping", "migrate_index", "create_alias", "list_alias", "reindex", "set_boost", "list_boost", "search_stats", "click_stats", "add_synonym", "delete_synonym", "list_synonym", "adfeed_report", "refresh_index", "list_hidden", "list_submission", "find_duplicates", "backfill_pinyin"}
+	for _, v := range cmds{
+		if text == v{
+			return true
+		}
+	}
+	return false
+}
+
+var zincClient *zincsearch.Client
+var zincClientOnce sync.Once
+// 写入和写入前的查询走接口，kkoa_bot_test.go里换成zincsearch.Memory
+var zincIndexer zincsearch.Indexer
+var zincSearcher zincsearch.Searcher
+
+// zincsearch客户端内部有连接池，全局复用一个
+func getZincClient()*zincsearch.Client{
+	zincClientOnce.Do(func(){
+		zincClient = zincsearch.NewClient(zincsearch_url, zincsearch_user, zincsearch_passwd)
+		if zincIndexer == nil{
+			zincIndexer = zincClient
+		}
+		if zincSearcher == nil{
+			zincSearcher = zincClient
+		}
+	})
+	return zincClient
+}
+
+func getZincIndexer()zincsearch.Indexer{
+	getZincClient()
+	return cacheInvalidator{zincIndexer}
+}
+
+// 文档已经存在时沿用原来的写入时间，重新添加不会被订阅当成新文档推送
+func documentCreatedAt(index string, id string)int64{
+	getZincClient()
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+	result, err := zincSearcher.Query(ctx, index, &zincsearch.QueryRequest{
+		Query: zincsearch.Term("_id", id),
+		Size: 1,
+		Source: []string{"created_at"},
+	})
+	if err != nil{
+		lib.XLogErr("query created_at", index, id, err)
+	}else if len(result.Hits.Hits) > 0{
+		if ts, ok := result.Hits.Hits[0].Source["created_at"].(float64); ok && ts > 0{
+			return int64(ts)
+		}
+	}
+	return time.Now().Unix()
+}
+
+// 文档写入成功后让search_bot的搜索缓存失效
+type cacheInvalidator struct{
+	zincsearch.Indexer
+}
+
+func (c cacheInvalidator) InsertDocument(indexName string, document interface{})error{
+	return markIngest(invalidateSearchCache(c.Indexer.InsertDocument(indexName, document)))
+}
+
+func (c cacheInvalidator) UpdateDocument(indexName, docID string, document interface{})error{
+	return markIngest(invalidateSearchCache(c.Indexer.UpdateDocument(indexName, docID, document)))
+}
+
+// 记录最后一次写入文档的时间，search_bot在一批写入结束后执行订阅推送
+func markIngest(err error)error{
+	if err != nil{
+		return err
+	}
+	if err := db.Set("zincsearch_bot_last_ingest", strconv.FormatInt(time.Now().Unix(), 10)); err != nil{
+		lib.XLogErr("mark ingest", err)
+	}
+	return nil
+}
+
+func (c cacheInvalidator) UpdateFields(indexName, docID string, fields map[string]interface{})error{
+	return invalidateSearchCache(c.Indexer.UpdateFields(indexName, docID, fields))
+}
+
+func (c cacheInvalidator) DeleteDocument(indexName, docID string)error{
+	return invalidateSearchCache(c.Indexer.DeleteDocument(indexName, docID))
+}
+
+// search_bot的缓存key带版本号，版本号加一后旧缓存不再命中
+func invalidateSearchCache(err error)error{
+	if err != nil{
+		return err
+	}
+	if _, err := db.Incr("zincsearch_bot_cache_version"); err != nil{
+		lib.XLogErr("invalidate search cache", err)
+	}
+	return nil
+}
+
+// 在redis事务里修改广告列表并让缓存失效，search_bot自助购买同时写入时不会丢记录
+func updateAdFeeds(key string, fn func(list *model.AdFeedList))error{
+	var list model.AdFeedList
+	return invalidateSearchCache(db.UpdateStruct(key, &list, func()error{
+		fn(&list)
+		return nil
+	}))
+}
+
+func createIndex(index_name string)error{
+	client := getZincClient()
+	settings := zincsearch.NewIndex().SetShardNum(3).Settings(index_name, zincsearch.DocumentMapping())
+	settings.Settings = zincsearch.DocumentSettings()
+	return client.CreateIndex(settings)
+}
+
+func showMapping(chatid int64, text string)error{
+	index_name := strings.TrimSpace(text)
+	client := getZincClient()
+	mappings, err := client.GetMapping(index_name)
+	if err != nil{
+		sendText(chatid, "操作失败")
+		return err
+	}
+	settings, err := client.GetSettings(index_name)
+	if err != nil{
+		sendText(chatid, "操作失败")
+		return err
+	}
+	mapping_str, _ := json.MarshalIndent(mappings, "", "  ")
+	settings_str, _ := json.MarshalIndent(settings, "", "  ")
+	sendText(chatid, "mappings:\n" + string(mapping_str) + "\nsettings:\n" + string(settings_str))
+	return nil
+}
+
+func diffMapping(chatid int64, text string)error{
+	index_name := strings.TrimSpace(text)
+	client := getZincClient()
+	diff, reindex, err := client.PlanMigration(index_name, zincsearch.DocumentSchema())
+	if err != nil{
+		sendText(chatid, "操作失败")
+		return err
+	}
+	text = diff.String()
+	if reindex{
+		text += "\n有字段变更，需要执行migrate_index重建索引"
+	}
+	sendText(chatid, text)
+	return nil
+}
+
+// 新增字段直接追加，字段变更则拷贝到新索引；别名走零停机重建
+func migrateIndex(chatid int64, text string)error{
+	index_name := strings.TrimSpace(text)
+	client := getZincClient()
+	target, err := client.ResolveIndex(index_name)
+	if err != nil{
+		sendText(chatid, "操作失败: " + err.Error())
+		return err
+	}
+	if target != index_name{
+		_, reindex, err := client.PlanMigration(target, zincsearch.DocumentSchema())
+		if err != nil{
+			sendText(chatid, "操作失败")
+			return err
+		}
+		if reindex{
+			go reindexAlias(chatid, index_name)
+			return nil
+		}
+	}
+	new_index, diff, err := client.Migrate(target, zincsearch.DocumentSchema())
+	if err != nil{
+		sendText(chatid, "迁移失败: " + err.Error())
+		return err
+	}
+	if diff.IsEmpty(){
+		sendText(chatid, "mapping一致，无需迁移")
+	}else if new_index == target{
+		sendText(chatid, "已追加字段:\n" + diff.String())
+		// 追加的拼音字段旧文档里没有值，在后台补上
+		go backfillPinyin(chatid, target)
+	}else{
+		sendText(chatid, "已重建到新索引 " + new_index + "，请用create_alias把别名指向新索引:\n" + diff.String())
+	}
+	return nil
+}
+
+// index_name alias
+func createAlias(chatid int64, text string)error{
+	values := strings.Fields(text)
+	if len(values) != 2{
+		sendText(chatid, "操作失败，请按照以下格式输入：index_name alias")
+		return nil
+	}
+	client := getZincClient()
+	old_indexes, _ := client.ResolveAlias(values[1])
+	old_index := ""
+	if len(old_indexes) > 0{
+		old_index = old_indexes[0]
+	}
+	if err := client.SwapAlias(values[1], old_index, values[0]); err != nil{
+		sendText(chatid, "操作失败: " + err.Error())
+		return err
+	}
+	sendText(chatid, values[1] + " => " + values[0])
+	return nil
+}
+
+func listAlias(chatid int64, text string)error{
+	alias := strings.TrimSpace(text)
+	client := getZincClient()
+	indexes, err := client.ResolveAlias(alias)
+	if err != nil{
+		sendText(chatid, "操作失败")
+		return err
+	}
+	if len(indexes) == 0{
+		sendText(chatid, alias + " 不是别名")
+		return nil
+	}
+	sendText(chatid, alias + " => " + strings.Join(indexes, ","))
+	return nil
+}
+
+// 后台重建别名指向的索引，耗时较长，完成后通知管理员
+func reindexAlias(chatid int64, alias string){
+	defer func() {
+		if err := recover(); err != nil {
+			lib.XLogErr("excption", err)
+		}
+	}()
+	// 只有本进程的写入会双写到新索引
+	sendText(chatid, "开始重建 " + alias + "，重建期间其它写入索引的bot请先停掉，否则写入会丢")
+	client := getZincClient()
+	new_index, err := client.ReindexAlias(alias, zincsearch.DocumentSchema())
+	if err != nil{
+		lib.XLogErr("ReindexAlias", alias, new_index, err)
+		sendText(chatid, "重建失败，别名未切换: " + err.Error())
+		return
+	}
+	sendText(chatid, "重建完成，" + alias + " => " + new_index)
+}
+
+// 给旧文档补上拼音字段，耗时较长，完成后通知管理员
+func backfillPinyin(chatid int64, name string){
+	defer func() {
+		if err := recover(); err != nil {
+			lib.XLogErr("excption", err)
+		}
+	}()
+	if name == ""{
+		name = g_sRefreshIndex
+	}
+	client := getZincClient()
+	index, err := client.ResolveIndex(name)
+	if err != nil{
+		sendText(chatid, "补充拼音失败: " + err.Error())
+		return
+	}
+	count, err := client.BackfillPinyin(index)
+	if count > 0{
+		invalidateSearchCache(nil)
+	}
+	if err != nil{
+		lib.XLogErr("BackfillPinyin", index, count, err)
+		sendText(chatid, fmt.Sprintf("补充拼音中途出错，已更新%d个文档: %s", count, err.Error()))
+		return
+	}
+	sendText(chatid, fmt.Sprintf("%s 补充拼音完成，更新%d个文档", index, count))
+}
+
+// 查询频道人数
+func getChatMemberCount(user_name string)(int, error){
+	config := model.GetChatMemberCountConfig{ChatID: "@" + user_name}
+	param, err := json.Marshal(config)
+	if err != nil{
+		return 0, err
+	}
+	rsp, err := tb.Request("getchatmembercount", string(param))
+	if err != nil{
+		return 0, err
+	}
+	var result model.IntResult
+	if err := json.Unmarshal([]byte(rsp), &result); err != nil{
+		return 0, err
+	}
+	if !result.OK{
+		return 0, fmt.Errorf("getchatmembercount fail: %s", rsp)
+	}
+	return result.Result, nil
+}
+
+// 频道被删除、改名或者改成私密后getChat返回chat not found
+func isChatMissing(err error)bool{
+	return err != nil && strings.Contains(err.Error(), "chat not found")
+}
+
+type refreshResult struct{
+	Scanned int
+	Updated int
+	Failed int
+	Missing []string
+}
+
+// 定时刷新索引里频道的人数和标题
+func runRefresher(){
+	if g_sRefreshIndex == "" || g_refreshInterval <= 0{
+		return
+	}
+	for range time.Tick(g_refreshInterval){
+		result, err := refreshIndex(g_sRefreshIndex)
+		if err != nil{
+			lib.XLogErr("refreshIndex", g_sRefreshIndex, err)
+		}
+		if adminchatid != 0 && (err != nil || len(result.Missing) > 0){
+			sendText(adminchatid, formatRefreshResult(g_sRefreshIndex, result, err))
+		}
+	}
+}
+
+func refreshIndexCommand(chatid int64, name string){
+	defer func() {
+		if err := recover(); err != nil {
+			lib.XLogErr("excption", err)
+		}
+	}()
+	if name == ""{
+		name = g_sRefreshIndex
+	}
+	sendText(chatid, "开始刷新 " + name)
+	result, err := refreshIndex(name)
+	sendText(chatid, formatRefreshResult(name, result, err))
+}
+
+func formatRefreshResult(name string, result refreshResult, err error)string{
+	text := fmt.Sprintf("刷新%s: 检查%d个频道，更新%d个，失败%d个", name, result.Scanned, result.Updated, result.Failed)
+	if err != nil{
+		text += "\n中途出错: " + err.Error()
+	}
+	if len(result.Missing) > 0{
+		if g_bRefreshDelete{
+			text += "\n已删除不存在的频道:\n"
+		}else{
+			text += "\n频道不存在，确认后用delete_document删除:\n"
+		}
+		text += strings.Join(result.Missing, "\n")
+	}
+	return text
+}
+
+// 遍历索引里的telegram频道，重新获取人数和标题后只更新这几个字段
+// 扫描和写入之间校验、合并重复也会改文档，整篇写回会把hidden、tags覆盖成扫描时的旧值
+// 不存在的频道按refresh_delete配置删除，或者记到zincsearch_bot_missing_channels里等管理员确认
+func refreshIndex(name string)(refreshResult, error){
+	var result refreshResult
+	if !g_refresh_mutex.TryLock(){
+		return result, fmt.Errorf("上一次刷新还没结束")
+	}
+	defer g_refresh_mutex.Unlock()
+
+	client := getZincClient()
+	index, err := client.ResolveIndex(name)
+	if err != nil{
+		return result, err
+	}
+	err = client.Scan(index, 100, func(hits []zincsearch.Hit)error{
+		for _, hit := range hits{
+			doc := zincsearch.DocumentFromHit(hit)
+			if doc.ContactType != "" && doc.ContactType != "telegram"{
+				continue
+			}
+			result.Scanned++
+			g_refreshLimiter.Wait()
+			config := model.GetChatConfig{ChatID: "@" + doc.ID}
+			if err := tb.Call(&config); err != nil{
+				if isChatMissing(err){
+					result.Missing = append(result.Missing, doc.ID)
+				}else{
+					lib.XLogErr("refresh getchat", doc.ID, err)
+					result.Failed++
+				}
+				continue
+			}
+			g_refreshLimiter.Wait()
+			count, err := getChatMemberCount(doc.ID)
+			if err != nil{
+				lib.XLogErr("refresh getchatmembercount", doc.ID, err)
+				result.Failed++
+				continue
+			}
+			db.DelFromSet("zincsearch_bot_missing_channels", doc.ID)
+			if err := client.UpdateFields(index, hit.ID, refreshFields(config.Response.Title, count, time.Now().Unix())); err != nil{
+				lib.XLogErr("refresh update", doc.ID, err)
+				result.Failed++
+				continue
+			}
+			result.Updated++
+		}
+		return nil
+	})
+	if result.Updated > 0{
+		invalidateSearchCache(nil)
+	}
+	if err != nil{
+		return result, err
+	}
+	for _, id := range result.Missing{
+		if g_bRefreshDelete{
+			if err := getZincIndexer().DeleteDocument(index, id); err != nil{
+				lib.XLogErr("delete missing channel", id, err)
+			}
+			db.DelFromSet("zincsearch_bot_missing_channels", id)
+		}else{
+			db.AddToSet("zincsearch_bot_missing_channels", id)
+		}
+	}
+	return result, nil
+}
+
+// 刷新时写回的字段，标题变了拼音也要跟着变
+func refreshFields(title string, count int, now int64)map[string]interface{}{
+	full, initials := zincsearch.ToPinyin(title)
+	return map[string]interface{}{
+		"title": title,
+		"user_count": count,
+		"refreshed_at": now,
+		"title_pinyin": full,
+		"title_initials": initials,
+	}
+}
+
+// 检测文档的链接是否失效，返回true表示确定失效，error表示这次没检测成功
+// telegram频道检查频道是否存在，与你、飞机私聊的id是 频道_消息id，配置了verify_probe_chat时还会转发消息确认消息还在
+func probeDocument(doc zincsearch.Document)(bool, error){
+	channel := doc.ID
+	msgid := 0
+	if doc.ContactType == "yuni" || doc.ContactType == "siliao"{
+		idx := strings.LastIndex(doc.ID, "_")
+		id, err := strconv.Atoi(doc.ID[idx + 1:])
+		if idx <= 0 || err != nil{
+			return false, fmt.Errorf("invalid id %s", doc.ID)
+		}
+		channel = doc.ID[:idx]
+		msgid = id
+	}
+	g_refreshLimiter.Wait()
+	config := model.GetChatConfig{ChatID: "@" + channel}
+	if err := tb.Call(&config); err != nil{
+		return isChatMissing(err), err
+	}
+	if msgid == 0 || g_iVerifyProbeChat == 0{
+		return false, nil
+	}
+	g_refreshLimiter.Wait()
+	forward := model.ForwardMessageConfig{ChatID: g_iVerifyProbeChat, FromChatID: "@" + channel, MessageID: msgid}
+	if err := tb.Call(&forward); err != nil{
+		return strings.Contains(err.Error(), "message to forward not found"), err
+	}
+	del := model.DeleteMessageConfig{ChatID: g_iVerifyProbeChat, MessageID: forward.Response.MessageID}
+	tb.Call(&del)
+	return false, nil
+}
+
+func verifyFailKey(id string)string{
+	return "zincsearch_bot_verify_fail_" + id
+}
+
+// 管理员恢复后暂停检测，过期后重新检测
+func verifySkipKey(id string)string{
+	return "zincsearch_bot_verify_skip_" + id
+}
+
+// 检测失效链接的索引，没有单独配置时用refresh_index
+func verifyIndexName()string{
+	if g_sVerifyIndex != ""{
+		return g_sVerifyIndex
+	}
+	return g_sRefreshIndex
+}
+
+// 定时检测失效链接
+func runVerifier(){
+	if g_verifyInterval <= 0{
+		return
+	}
+	for range time.Tick(g_verifyInterval){
+		name := verifyIndexName()
+		if name == ""{
+			continue
+		}
+		hidden, err := verifyIndex(name)
+		if err != nil{
+			lib.XLogErr("verifyIndex", name, err)
+		}
+		if adminchatid != 0 && len(hidden) > 0{
+			sendHiddenDocs(adminchatid, "以下文档连续" + strconv.Itoa(g_iVerifyThreshold) + "次检测失效，已从搜索结果隐藏:", hidden)
+		}
+	}
+}
+
+// 遍历索引检测每个文档，连续失败次数记在redis里，成功一次就清零
+// 达到verify_threshold后给文档打上hidden标记，search_bot搜索时排除，返回新隐藏的文档id
+func verifyIndex(name string)([]string, error){
+	if !g_verify_mutex.TryLock(){
+		return nil, fmt.Errorf("上一次检测还没结束")
+	}
+	defer g_verify_mutex.Unlock()
+
+	client := getZincClient()
+	indexer := getZincIndexer()
+	index, err := client.ResolveIndex(name)
+	if err != nil{
+		return nil, err
+	}
+	migrateVerifySets(indexer, index)
+	var hidden []string
+	err = client.Scan(index, 100, func(hits []zincsearch.Hit)error{
+		for _, hit := range hits{
+			doc := zincsearch.DocumentFromHit(hit)
+			if skip, _ := db.Exists(verifySkipKey(doc.ID)); skip{
+				continue
+			}
+			missing, err := probeDocument(doc)
+			if err != nil && !missing{
+				lib.XLogErr("probe", doc.ID, err)
 				continue
 			}
-			// 管理员回复的消息，转发给原始发消息的用户
-			if update.Message.ReplyToMessage != nil && update.Message.ReplyToMessage.ForwardFrom != nil{
-				forwardMessageToChat(update.Message, update.Message.ReplyToMessage.ForwardFrom.ID)
+			if !missing{
+				db.Del(verifyFailKey(doc.ID))
+				if doc.Hidden{
+					if err := setDocumentHidden(indexer, index, doc.ID, false); err != nil{
+						lib.XLogErr("unhide doc", doc.ID, err)
+					}
+				}
 				continue
 			}
-			if isCommand(update.Message.Text){
-				if update.Message.Text == "clear"{
-					cur_cmd = ""
-					lib.XLogInfo("clear command")
-				}else{
-					cur_cmd = update.Message.Text
-					lib.XLogInfo("change command", cur_cmd)
-				}
+			count, err := db.Incr(verifyFailKey(doc.ID))
+			if err != nil{
+				lib.XLogErr("incr verify fail", doc.ID, err)
 				continue
 			}
-			if cur_cmd != ""{
-				// 处理媒体组消息
-				if (cur_cmd == "import_js"|| cur_cmd == "import_yunijs") && len(update.Message.MediaGroupID) > 0{
-					cache.handleMediaGroup(update.Message)
-				}else{
-					handleCommand(cur_cmd, update.Message)
-				}
+			db.Expire(verifyFailKey(doc.ID), 30 * 24 * time.Hour)
+			if doc.Hidden || count < int64(g_iVerifyThreshold){
+				continue
 			}
+			if err := setDocumentHidden(indexer, index, doc.ID, true); err != nil{
+				lib.XLogErr("hide doc", doc.ID, err)
+				continue
+			}
+			hidden = append(hidden, doc.ID)
 		}
-	}
+		return nil
+	})
+	return hidden, err
 }
 
-func forwardMessageToChat(msg *model.Message, chatid int64){
-	config := model.SendMessageConfig{
-		ChatID: chatid,
-		Text: msg.Text,
-		Entities: msg.Entities,
+// 部分更新文档的hidden字段，其它字段不变
+func setDocumentHidden(indexer zincsearch.Indexer, index string, id string, hidden bool)error{
+	return indexer.UpdateFields(index, id, map[string]interface{}{"hidden": hidden})
+}
+
+// 以前隐藏的文档和恢复的文档记在两个redis集合里，迁移成文档的hidden字段和带过期时间的key
+// 迁移成功的从集合里删掉，失败的下次检测时再试
+func migrateVerifySets(indexer zincsearch.Indexer, index string){
+	ids, _ := db.GetSetMembers("zincsearch_bot_hidden_docs")
+	for _, id := range ids{
+		err := setDocumentHidden(indexer, index, id, true)
+		var status_err *zincsearch.StatusError
+		if err != nil && !(errors.As(err, &status_err) && status_err.StatusCode == http.StatusNotFound){
+			lib.XLogErr("migrate hidden doc", id, err)
+			continue
+		}
+		db.DelFromSet("zincsearch_bot_hidden_docs", id)
+	}
+	ids, _ = db.GetSetMembers("zincsearch_bot_verify_skip")
+	for _, id := range ids{
+		if err := db.SetWithExpire(verifySkipKey(id), "1", g_verifySkipTTL); err != nil{
+			lib.XLogErr("migrate verify skip", id, err)
+			continue
+		}
+		db.DelFromSet("zincsearch_bot_verify_skip", id)
 	}
-	tb.CallV2(&config)
 }
 
-func forwardMessage(msg *model.Message){
-	if strings.HasPrefix(msg.Text, "adddoc") || strings.HasPrefix(msg.Text, "/adddoc"){
-		items := strings.Split(msg.Text, " ")
-		if len(items) < 4{
-			sendText(msg.Chat.ID, "操作失败，格式不对")
-			return
+// 发送文档列表给管理员，每个文档一行删除、恢复按钮
+func sendHiddenDocs(chatid int64, title string, ids []string){
+	const max_rows = 50
+	text := title + "\n" + strings.Join(ids, "\n")
+	var rows [][]model.InlineKeyboardButton
+	for i, id := range ids{
+		if i >= max_rows{
+			text += "\n只显示前" + strconv.Itoa(max_rows) + "个按钮，处理完后发送list_hidden查看剩下的"
+			break
 		}
-		cmd := "search_qm " + items[1] + " " + items[2] + " qm " + items[3] + " "
-		for i, v := range items{
-			if i < 4{
-				continue
-			}
-			cmd += v + " "
+		del_data := "dead$$del$$" + id
+		keep_data := "dead$$keep$$" + id
+		// 按钮数据最多64字节
+		if len(keep_data) > 64{
+			continue
 		}
-		insertDocument(msg.Chat.ID, cmd)
-		return
+		rows = append(rows, []model.InlineKeyboardButton{
+			{Text: "🗑 删除 " + id, CallbackData: &del_data},
+			{Text: "↩️ 恢复", CallbackData: &keep_data},
+		})
 	}
-	if adminchatid == 0{
-		sendText(msg.Chat.ID, "消息发送失败，请稍后重试...")
+	config := model.SendMessageConfig{ChatID: chatid, Text: text}
+	if len(rows) > 0{
+		config.ReplyMarkup = model.InlineKeyboardMarkup{InlineKeyboard: rows}
 	}
-	config := model.ForwardMessageConfig{
-		ChatID: adminchatid,
-		FromChatID: msg.Chat.ID,
-		MessageID: msg.MessageID,
+	tb.Call(&config)
+}
+
+func listHidden(chatid int64)error{
+	client := getZincClient()
+	index, err := client.ResolveIndex(verifyIndexName())
+	if err != nil{
+		sendText(chatid, "操作失败")
+		return err
 	}
-	tb.CallV2(&config)
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+	result, err := client.Query(ctx, index, &zincsearch.QueryRequest{
+		Query: zincsearch.Term("hidden", true),
+		Size: 500,
+		Sort: []string{"_id"},
+		Source: []string{"hidden"},
+	})
+	if err != nil{
+		sendText(chatid, "操作失败")
+		return err
+	}
+	if len(result.Hits.Hits) == 0{
+		sendText(chatid, "没有隐藏的文档")
+		return nil
+	}
+	ids := make([]string, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits{
+		ids = append(ids, hit.ID)
+	}
+	sendHiddenDocs(chatid, "已隐藏的文档:", ids)
+	return nil
 }
 
-func isCommand(text string)bool{
-	cmds := []string{"get_js_report", "import_yunijs", "import_index", "report_index", "report_detail", "import_report", "clear_jsindex", "show_jsdetail", "list_jsindex", "import_js", "create_index", "list_index", "delete_index", "insert_document", "clear", "delete_document", "add_adfeed", "list_adfeed", "delete_adfeed", "add_topfeed", "list_topfeed", "delete_topfeed", "get_chatid"}
-	for _, v := range cmds{
-		if text == v{
-			return true
+// 删除确认删除，恢复后verify_skip_days内不再检测这个文档
+func handleDeadLink(callback *model.CallbackQuery, action string, id string){
+	indexer := getZincIndexer()
+	index, err := indexer.ResolveIndex(verifyIndexName())
+	if err != nil{
+		answerCallback(callback, "操作失败: " + err.Error())
+		return
+	}
+	text := ""
+	if action == "del"{
+		if err := indexer.DeleteDocument(index, id); err != nil{
+			lib.XLogErr("delete dead doc", id, err)
+			answerCallback(callback, "删除失败: " + err.Error())
+			return
 		}
+		text = "已删除 " + id
+	}else if action == "keep"{
+		if err := setDocumentHidden(indexer, index, id, false); err != nil{
+			lib.XLogErr("unhide doc", id, err)
+			answerCallback(callback, "恢复失败: " + err.Error())
+			return
+		}
+		db.SetWithExpire(verifySkipKey(id), "1", g_verifySkipTTL)
+		text = fmt.Sprintf("已恢复 %s，%d天内不再检测", id, int(g_verifySkipTTL.Hours() / 24))
+	}else{
+		return
 	}
-	return false
+	db.Del(verifyFailKey(id))
+	answerCallback(callback, text)
 }
 
-func createIndex(index_name string)error{
-	client := zincsearch.NewClient(zincsearch_url, zincsearch_user, zincsearch_passwd)
-	settings := &zincsearch.IndexSettings{
-		Name: index_name,
-		NumberOfShards:   3,
-		Mappings: map[string]interface{}{
-			"properties": map[string]interface{}{
-				"title": zincsearch.FieldSetting{Type:"text", Index:true},
-				"description":   zincsearch.FieldSetting{Type: "text", Index:true},
-				"chat_id":  zincsearch.FieldSetting{Type: "keyword"},
-				"user_count": zincsearch.FieldSetting{Type: "integer", Index:true},
-				"js_name": zincsearch.FieldSetting{Type:"text", Index:true},
-				"js_type": zincsearch.FieldSetting{Type: "keyword"},
-				"tags": zincsearch.FieldSetting{Type: "text", Index:true},
-				"location": zincsearch.FieldSetting{Type: "text", Index:true},
-			},
-		},
+func answerCallback(callback *model.CallbackQuery, text string){
+	config := model.AnswerCallbackQueryConfig{CallbackID: callback.ID, Text: text, ShowAlert: true}
+	tb.Call(&config)
+}
+
+// 管理员点击的按钮，数据是 类型$$操作$$id
+func handleCallback(callback *model.CallbackQuery){
+	defer func() {
+		if err := recover(); err != nil {
+			lib.XLogErr("excption", err)
+		}
+	}()
+	if callback.From == nil || callback.From.UserName != adminuser{
+		lib.XLogErr("not admin callback", callback.Data)
+		return
+	}
+	values := strings.SplitN(callback.Data, "$$", 3)
+	if len(values) != 3{
+		lib.XLogErr("invalid callback", callback.Data)
+		return
+	}
+	if values[0] == "dead"{
+		handleDeadLink(callback, values[1], values[2])
+	}else if values[0] == "submit"{
+		reviewSubmission(callback, values[1], values[2])
+	}else if values[0] == "dup"{
+		handleDuplicate(callback, values[1], values[2])
 	}
-	return client.CreateIndex(settings)
 }
 
 func listIndex(chatid int64)error{
-	client := zincsearch.NewClient(zincsearch_url, zincsearch_user, zincsearch_passwd)
+	client := getZincClient()
 	indexs, err := client.ListIndexes()
 	if err != nil{
 		sendText(chatid, "操作失败")
@@ -384,7 +1470,7 @@ func listIndex(chatid int64)error{
 }
 
 func deleteIndex(index_name string)error{
-	client := zincsearch.NewClient(zincsearch_url, zincsearch_user, zincsearch_passwd)
+	client := getZincClient()
 	return client.DeleteIndex(index_name)
 }
 
@@ -417,7 +1503,7 @@ func insertYuniJs(chatid int64, contact_type, text string)error{
 		title += "的飞机号"
 	}
 
-	client := zincsearch.NewClient(zincsearch_url, zincsearch_user, zincsearch_passwd)
+	client := getZincIndexer()
 	doc := zincsearch.Document{
 		Title: title,
 		Description: "",
@@ -429,7 +1515,13 @@ func insertYuniJs(chatid int64, contact_type, text string)error{
 		Tags: str_tags,
 		ContactType: contact_type,
 	}
-	return client.UpdateDocument(values[0], values[1], doc)
+	doc.FillPinyin()
+	index, err := client.ResolveIndex(values[0])
+	if err != nil{
+		return err
+	}
+	doc.CreatedAt = documentCreatedAt(index, values[1])
+	return client.UpdateDocument(index, values[1], doc)
 }
 
 func insertDocument(chatid int64, text string)error{
@@ -482,7 +1574,7 @@ func insertDocument(chatid int64, text string)error{
 		str_tags += "#" + v
 	}
 
-	client := zincsearch.NewClient(zincsearch_url, zincsearch_user, zincsearch_passwd)
+	client := getZincIndexer()
 	doc := zincsearch.Document{
 		Title: chat.Title,
 		Description: "",
@@ -493,8 +1585,15 @@ func insertDocument(chatid int64, text string)error{
 		Location: values[4],
 		Tags: str_tags,
 		ContactType: "telegram",
+		RefreshedAt: time.Now().Unix(),
+	}
+	doc.FillPinyin()
+	index, err := client.ResolveIndex(values[0])
+	if err != nil{
+		return err
 	}
-	return client.UpdateDocument(values[0], user_name, doc)
+	doc.CreatedAt = documentCreatedAt(index, user_name)
+	return client.UpdateDocument(index, user_name, doc)
 }
 
 func deleteDocument(chatid int64, text string)error{
@@ -504,8 +1603,12 @@ func deleteDocument(chatid int64, text string)error{
 		sendText(chatid, "操作失败，请按照以下格式输入：index_name chatid")
 		return nil
 	}
-	client := zincsearch.NewClient(zincsearch_url, zincsearch_user, zincsearch_passwd)
-	return client.DeleteDocument(values[0], values[1])
+	client := getZincIndexer()
+	index, err := client.ResolveIndex(values[0])
+	if err != nil{
+		return err
+	}
+	return client.DeleteDocument(index, values[1])
 }
 
 func getAdFeeds(key string)model.AdFeedList{
@@ -518,13 +1621,12 @@ func getAdFeeds(key string)model.AdFeedList{
 
 func addAdfeed(chatid int64, text string)error{
 	data := strings.TrimSpace(text)
-	values := strings.Split(data, " ")
-	if len(values) != 2{
-		sendText(chatid, "操作失败，请按照以下格式输入：chatid order")
+	values := strings.Fields(data)
+	if len(values) != 2 && len(values) != 5{
+		sendText(chatid, "操作失败，请按照以下格式输入：chatid order [开始日期 结束日期 每天展示上限]，日期格式2006-01-02")
 		return nil
 	}
 
-	list := getAdFeeds("zincsearch_bot_adfeeds")
 	chatinfo_config := model.GetChatConfig{ChatID: "@" + values[0]}
 	err := tb.CallV2(&chatinfo_config)
 	if err != nil{
@@ -544,9 +1646,13 @@ func addAdfeed(chatid int64, text string)error{
 		Order: order,
 		TS: time.Now().Unix() + 3600 * 24 * 30,
 	}
-	list.Feeds = append(list.Feeds, feed)
-
-	err = db.SetStruct("zincsearch_bot_adfeeds", list)
+	if err := parseCampaign(values[2:], &feed); err != nil{
+		sendText(chatid, "操作失败，" + err.Error())
+		return err
+	}
+	err = updateAdFeeds("zincsearch_bot_adfeeds", func(list *model.AdFeedList){
+		list.Feeds = append(list.Feeds, feed)
+	})
 	if err != nil {
 		sendText(chatid, "操作失败")
 		return err
@@ -554,11 +1660,86 @@ func addAdfeed(chatid int64, text string)error{
 	return nil
 }
 
+// 可选的投放计划: 开始日期 结束日期 每天展示上限，结束日期当天也投放
+func parseCampaign(values []string, feed *model.AdFeed)error{
+	if len(values) == 0{
+		return nil
+	}
+	start, err := time.ParseInLocation("2006-01-02", values[0], time.Local)
+	if err != nil{
+		return fmt.Errorf("开始日期格式错误")
+	}
+	end, err := time.ParseInLocation("2006-01-02", values[1], time.Local)
+	if err != nil || end.Before(start){
+		return fmt.Errorf("结束日期格式错误")
+	}
+	daily_cap, err := strconv.Atoi(values[2])
+	if err != nil || daily_cap < 0{
+		return fmt.Errorf("每天展示上限格式错误")
+	}
+	feed.Start = start.Unix()
+	feed.TS = end.AddDate(0, 0, 1).Unix()
+	feed.DailyCap = daily_cap
+	return nil
+}
+
+func formatCampaign(feed model.AdFeed)string{
+	text := ""
+	if feed.Start > 0{
+		text += time.Unix(feed.Start, 0).Format("2006-01-02")
+	}
+	text += "~" + time.Unix(feed.TS, 0).Format("2006-01-02")
+	if feed.DailyCap > 0{
+		text += " 每天" + strconv.Itoa(feed.DailyCap) + "次"
+	}
+	return text
+}
+
+// 广告展示统计，输入统计最近几天，默认7天
+func adfeedReport(chatid int64, text string)error{
+	days, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || days <= 0{
+		days = 7
+	}
+	if days > 90{
+		days = 90
+	}
+	now := time.Now()
+	var keys []string
+	today := make(map[string]float64)
+	for i := 0; i < days; i++{
+		keys = append(keys, "zincsearch_bot_ad_impr_" + now.AddDate(0, 0, -i).Format("20060102"))
+	}
+	if members, err := db.ZRevRangeWithScores(keys[0], 0, -1); err == nil{
+		for _, v := range members{
+			today[v.Member] = v.Score
+		}
+	}
+	dest := "zincsearch_bot_ad_impr_tmp_" + strconv.FormatInt(chatid, 10)
+	if err := db.ZUnionStore(dest, keys...); err != nil{
+		return err
+	}
+	members, err := db.ZRevRangeWithScores(dest, 0, -1)
+	db.Del(dest)
+	if err != nil{
+		return err
+	}
+	result := fmt.Sprintf("最近%d天广告展示(总计/今天):\n", days)
+	for _, v := range members{
+		result += fmt.Sprintf("%s %d/%d\n", v.Member, int(v.Score), int(today[v.Member]))
+	}
+	if len(members) == 0{
+		result += "暂无展示"
+	}
+	sendText(chatid, result)
+	return nil
+}
+
 func listAdfeed(chatid int64)error{
 	list := getAdFeeds("zincsearch_bot_adfeeds")
 	text := ""
 	for _, v := range list.Feeds{
-		text += v.Title + " " + v.ChatID + " " + strconv.Itoa(v.Order) + "\n"
+		text += v.Title + " " + v.ChatID + " " + strconv.Itoa(v.Order) + " " + formatCampaign(v) + "\n"
 	}
 	sendText(chatid, text)
 	return nil
@@ -566,25 +1747,19 @@ func listAdfeed(chatid int64)error{
 
 func deleteAdfeed(chatid int64, text string)error{
 	channelid := strings.TrimSpace(text)
-	list := getAdFeeds("zincsearch_bot_adfeeds")
-	var newlist  model.AdFeedList
-	for _, v := range list.Feeds{
-		if v.ChatID != channelid{
-			newlist.Feeds = append(newlist.Feeds, v)
-		}
-	}
-	return db.SetStruct("zincsearch_bot_adfeeds", newlist)
+	return updateAdFeeds("zincsearch_bot_adfeeds", func(list *model.AdFeedList){
+		list.Feeds = removeAdFeed(list.Feeds, channelid)
+	})
 }
 
 func addTopfeed(chatid int64, text string)error{
 	data := strings.TrimSpace(text)
-	values := strings.Split(data, " ")
-	if len(values) != 3{
-		sendText(chatid, "操作失败，请按照以下格式输入：关键词 chatid")
+	values := strings.Fields(data)
+	if len(values) != 3 && len(values) != 6{
+		sendText(chatid, "操作失败，请按照以下格式输入：关键词 chatid order [开始日期 结束日期 每天展示上限]，日期格式2006-01-02")
 		return nil
 	}
 	key := "zincsearch_bot_topfeeds_" + base64.StdEncoding.EncodeToString([]byte(values[0]))
-	list := getAdFeeds(key)
 
 	chatinfo_config := model.GetChatConfig{ChatID: "@" + values[1]}
 	err := tb.CallV2(&chatinfo_config)
@@ -604,10 +1779,15 @@ func addTopfeed(chatid int64, text string)error{
 		ChatID: values[1],
 		Order: order,
 		TS: time.Now().Unix() + 3600 * 24 * 30,
+		Keyword: values[0],
 	}
-	list.Feeds = append(list.Feeds, feed)
-
-	err = db.SetStruct(key, list)
+	if err := parseCampaign(values[3:], &feed); err != nil{
+		sendText(chatid, "操作失败，" + err.Error())
+		return err
+	}
+	err = updateAdFeeds(key, func(list *model.AdFeedList){
+		list.Feeds = append(list.Feeds, feed)
+	})
 	if err != nil {
 		sendText(chatid, "操作失败")
 		return err
@@ -621,7 +1801,7 @@ func listTopfeed(chatid int64, text string)error{
 	list := getAdFeeds(key)
 	text = ""
 	for _, v := range list.Feeds{
-		text += v.Title + " " + v.ChatID + " " + strconv.Itoa(v.Order) + "\n"
+		text += v.Title + " " + v.ChatID + " " + strconv.Itoa(v.Order) + " " + formatCampaign(v) + "\n"
 	}
 	sendText(chatid, text)
 	return nil
@@ -635,14 +1815,285 @@ func deleteTopfeed(chatid int64, text string)error{
 		return nil
 	}
 	key := "zincsearch_bot_topfeeds_" + base64.StdEncoding.EncodeToString([]byte(values[0]))
-	list := getAdFeeds(key)
-	var newlist model.AdFeedList
-	for _, v := range list.Feeds{
-		if v.ChatID != values[1]{
-			newlist.Feeds = append(newlist.Feeds, v)
+	return updateAdFeeds(key, func(list *model.AdFeedList){
+		list.Feeds = removeAdFeed(list.Feeds, values[1])
+	})
+}
+
+func removeAdFeed(feeds []model.AdFeed, channelid string)[]model.AdFeed{
+	var result []model.AdFeed
+	for _, v := range feeds{
+		if v.ChatID != channelid{
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// 设置搜索排序加权，格式: 文档id 加权值，加权值为0时删除
+func setBoost(chatid int64, text string)error{
+	values := strings.Fields(text)
+	if len(values) != 2{
+		sendText(chatid, "操作失败，请按照以下格式输入：文档id 加权值")
+		return nil
+	}
+	boost, err := strconv.ParseFloat(values[1], 64)
+	if err != nil{
+		sendText(chatid, "操作失败，加权值转换失败")
+		return err
+	}
+	var list model.RankBoostList
+	if err := db.GetStruct("zincsearch_bot_boosts", &list); err != nil{
+		lib.XLogErr("empty boosts")
+	}
+	if list.Boosts == nil{
+		list.Boosts = make(map[string]float64)
+	}
+	if boost == 0{
+		delete(list.Boosts, values[0])
+	}else{
+		list.Boosts[values[0]] = boost
+	}
+	if err := invalidateSearchCache(db.SetStruct("zincsearch_bot_boosts", list)); err != nil{
+		sendText(chatid, "操作失败")
+		return err
+	}
+	sendText(chatid, "设置成功")
+	return nil
+}
+
+func listBoost(chatid int64)error{
+	var list model.RankBoostList
+	if err := db.GetStruct("zincsearch_bot_boosts", &list); err != nil{
+		lib.XLogErr("empty boosts")
+	}
+	text := ""
+	for id, boost := range list.Boosts{
+		text += id + " " + strconv.FormatFloat(boost, 'f', -1, 64) + "\n"
+	}
+	if text == ""{
+		text = "暂无加权"
+	}
+	sendText(chatid, text)
+	return nil
+}
+
+// 搜索统计，输入统计最近几天，默认1天
+func searchStats(chatid int64, text string)error{
+	days, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || days <= 0{
+		days = 1
+	}
+	if days > 30{
+		days = 30
+	}
+	now := time.Now()
+	var queryKeys, zeroKeys, clickKeys []string
+	result := "每日搜索人数:\n"
+	for i := 0; i < days; i++{
+		day := now.AddDate(0, 0, -i).Format("20060102")
+		queryKeys = append(queryKeys, "search_stats_queries_" + day)
+		zeroKeys = append(zeroKeys, "search_stats_zero_" + day)
+		clickKeys = append(clickKeys, "search_stats_clicks_" + day)
+		count, err := db.PFCount("search_stats_users_" + day)
+		if err != nil{
+			lib.XLogErr("PFCount", day, err)
+		}
+		result += day + " " + strconv.FormatInt(count, 10) + "\n"
+	}
+	sections := []struct{
+		title string
+		keys []string
+	}{
+		{"热门搜索", queryKeys},
+		{"无结果搜索", zeroKeys},
+		{"inline点击", clickKeys},
+	}
+	for _, section := range sections{
+		dest := "search_stats_tmp_" + strconv.FormatInt(chatid, 10)
+		if err := db.ZUnionStore(dest, section.keys...); err != nil{
+			return err
+		}
+		members, err := db.ZRevRangeWithScores(dest, 0, 19)
+		db.Del(dest)
+		if err != nil{
+			return err
+		}
+		result += "\n" + section.title + ":\n"
+		for i, v := range members{
+			result += fmt.Sprintf("%d. %s %d\n", i + 1, v.Member, int(v.Score))
+		}
+	}
+	sendText(chatid, result)
+	return nil
+}
+
+// 点击统计，输入统计最近几天，默认1天
+// 每个位置的展示数由search_bot统计，点击数由redirect_server统计
+func clickStats(chatid int64, text string)error{
+	days, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || days <= 0{
+		days = 1
+	}
+	if days > 30{
+		days = 30
+	}
+	now := time.Now()
+	keys := map[string][]string{}
+	for i := 0; i < days; i++{
+		day := now.AddDate(0, 0, -i).Format("20060102")
+		for _, name := range []string{"pos_impr", "pos_click", "doc", "adfeed", "topfeed"}{
+			keys[name] = append(keys[name], "click_stats_" + name + "_" + day)
+		}
+	}
+	dest := "click_stats_tmp_" + strconv.FormatInt(chatid, 10)
+	load := func(name string, limit int64)([]db.ZMember, error){
+		if err := db.ZUnionStore(dest, keys[name]...); err != nil{
+			return nil, err
+		}
+		defer db.Del(dest)
+		return db.ZRevRangeWithScores(dest, 0, limit - 1)
+	}
+	impressions, err := load("pos_impr", 1000)
+	if err != nil{
+		return err
+	}
+	clicks, err := load("pos_click", 1000)
+	if err != nil{
+		return err
+	}
+	mapClicks := make(map[string]float64, len(clicks))
+	for _, v := range clicks{
+		mapClicks[v.Member] = v.Score
+	}
+	// 按类型和位置排序
+	sort.Slice(impressions, func(i, j int)bool{
+		ki, pi := splitPosition(impressions[i].Member)
+		kj, pj := splitPosition(impressions[j].Member)
+		if ki != kj{
+			return ki < kj
+		}
+		return pi < pj
+	})
+	result := "位置 展示 点击 点击率\n"
+	for _, v := range impressions{
+		ctr := mapClicks[v.Member] / v.Score * 100
+		result += fmt.Sprintf("%s %d %d %.2f%%\n", v.Member, int(v.Score), int(mapClicks[v.Member]), ctr)
+	}
+	for _, section := range []struct{ name, title string }{{"adfeed", "推广点击"}, {"topfeed", "关键词广告点击"}, {"doc", "结果点击"}}{
+		members, err := load(section.name, 20)
+		if err != nil{
+			return err
+		}
+		result += "\n" + section.title + ":\n"
+		for i, v := range members{
+			result += fmt.Sprintf("%d. %s %d\n", i + 1, v.Member, int(v.Score))
+		}
+	}
+	sendText(chatid, result)
+	return nil
+}
+
+// "doc:3" => "doc", 3
+func splitPosition(member string)(string, int){
+	idx := strings.LastIndex(member, ":")
+	if idx == -1{
+		return member, 0
+	}
+	position, _ := strconv.Atoi(member[idx + 1:])
+	return member[:idx], position
+}
+
+func getSynonyms()model.SynonymList{
+	var list model.SynonymList
+	if err := db.GetStruct("zincsearch_bot_synonyms", &list); err != nil{
+		lib.XLogErr("empty synonyms")
+	}
+	return list
+}
+
+// 添加同义词，格式: 天河 天河区 TH，和已有的组有重叠时合并成一组
+func addSynonym(chatid int64, text string)error{
+	terms := strings.Fields(text)
+	if len(terms) < 2{
+		sendText(chatid, "操作失败，请按照以下格式输入：词1 词2 ...")
+		return nil
+	}
+	list := getSynonyms()
+	merged := map[string]bool{}
+	var group []string
+	add := func(term string){
+		key := strings.ToLower(term)
+		if !merged[key]{
+			merged[key] = true
+			group = append(group, term)
+		}
+	}
+	for _, term := range terms{
+		add(term)
+	}
+	var groups [][]string
+	for _, g := range list.Groups{
+		overlap := false
+		for _, term := range g{
+			if merged[strings.ToLower(term)]{
+				overlap = true
+				break
+			}
+		}
+		if !overlap{
+			groups = append(groups, g)
+			continue
+		}
+		for _, term := range g{
+			add(term)
+		}
+	}
+	list.Groups = append(groups, group)
+	if err := invalidateSearchCache(db.SetStruct("zincsearch_bot_synonyms", list)); err != nil{
+		sendText(chatid, "操作失败")
+		return err
+	}
+	sendText(chatid, "设置成功: " + strings.Join(group, " = "))
+	return nil
+}
+
+// 删除同义词，输入一个词从所在的组里删掉，剩一个词的组整组删除
+func deleteSynonym(chatid int64, text string)error{
+	term := strings.ToLower(strings.TrimSpace(text))
+	list := getSynonyms()
+	var groups [][]string
+	for _, g := range list.Groups{
+		var left []string
+		for _, v := range g{
+			if strings.ToLower(v) != term{
+				left = append(left, v)
+			}
+		}
+		if len(left) >= 2{
+			groups = append(groups, left)
 		}
 	}
-	return db.SetStruct(key, newlist)
+	list.Groups = groups
+	if err := invalidateSearchCache(db.SetStruct("zincsearch_bot_synonyms", list)); err != nil{
+		sendText(chatid, "操作失败")
+		return err
+	}
+	sendText(chatid, "删除成功")
+	return nil
+}
+
+func listSynonym(chatid int64)error{
+	list := getSynonyms()
+	text := ""
+	for _, g := range list.Groups{
+		text += strings.Join(g, " = ") + "\n"
+	}
+	if text == ""{
+		text = "暂无同义词"
+	}
+	sendText(chatid, text)
+	return nil
 }
 
 func insertForwardMessagev4(chatid int64, text string){
@@ -1332,6 +2783,74 @@ func handleCommand(cmd string, msg *model.Message){
 		if err := createIndex(msg.Text); err != nil{
 			lib.XLogErr("createIndex", err, msg.Text)
 		}
+	}else if cmd == "show_mapping"{
+		if err := showMapping(msg.Chat.ID, msg.Text); err != nil{
+			lib.XLogErr("showMapping", err, msg.Text)
+		}
+	}else if cmd == "diff_mapping"{
+		if err := diffMapping(msg.Chat.ID, msg.Text); err != nil{
+			lib.XLogErr("diffMapping", err, msg.Text)
+		}
+	}else if cmd == "migrate_index"{
+		if err := migrateIndex(msg.Chat.ID, msg.Text); err != nil{
+			lib.XLogErr("migrateIndex", err, msg.Text)
+		}
+	}else if cmd == "create_alias"{
+		if err := createAlias(msg.Chat.ID, msg.Text); err != nil{
+			lib.XLogErr("createAlias", err, msg.Text)
+		}
+	}else if cmd == "list_alias"{
+		if err := listAlias(msg.Chat.ID, msg.Text); err != nil{
+			lib.XLogErr("listAlias", err, msg.Text)
+		}
+	}else if cmd == "reindex"{
+		go reindexAlias(msg.Chat.ID, strings.TrimSpace(msg.Text))
+	}else if cmd == "refresh_index"{
+		go refreshIndexCommand(msg.Chat.ID, strings.TrimSpace(msg.Text))
+	}else if cmd == "find_duplicates"{
+		go findDuplicates(msg.Chat.ID, strings.TrimSpace(msg.Text))
+	}else if cmd == "backfill_pinyin"{
+		go backfillPinyin(msg.Chat.ID, strings.TrimSpace(msg.Text))
+	}else if cmd == "list_submission"{
+		if err := listSubmission(msg.Chat.ID); err != nil{
+			lib.XLogErr("listSubmission", err)
+		}
+	}else if cmd == "list_hidden"{
+		if err := listHidden(msg.Chat.ID); err != nil{
+			lib.XLogErr("listHidden", err)
+		}
+	}else if cmd == "set_boost"{
+		if err := setBoost(msg.Chat.ID, msg.Text); err != nil{
+			lib.XLogErr("setBoost", err, msg.Text)
+		}
+	}else if cmd == "list_boost"{
+		if err := listBoost(msg.Chat.ID); err != nil{
+			lib.XLogErr("listBoost", err)
+		}
+	}else if cmd == "search_stats"{
+		if err := searchStats(msg.Chat.ID, msg.Text); err != nil{
+			lib.XLogErr("searchStats", err, msg.Text)
+		}
+	}else if cmd == "click_stats"{
+		if err := clickStats(msg.Chat.ID, msg.Text); err != nil{
+			lib.XLogErr("clickStats", err, msg.Text)
+		}
+	}else if cmd == "add_synonym"{
+		if err := addSynonym(msg.Chat.ID, msg.Text); err != nil{
+			lib.XLogErr("addSynonym", err, msg.Text)
+		}
+	}else if cmd == "delete_synonym"{
+		if err := deleteSynonym(msg.Chat.ID, msg.Text); err != nil{
+			lib.XLogErr("deleteSynonym", err, msg.Text)
+		}
+	}else if cmd == "list_synonym"{
+		if err := listSynonym(msg.Chat.ID); err != nil{
+			lib.XLogErr("listSynonym", err)
+		}
+	}else if cmd == "adfeed_report"{
+		if err := adfeedReport(msg.Chat.ID, msg.Text); err != nil{
+			lib.XLogErr("adfeedReport", err, msg.Text)
+		}
 	}else if cmd == "list_index"{
 		if err := listIndex(msg.Chat.ID); err != nil{
 			lib.XLogErr("listIndex", err)
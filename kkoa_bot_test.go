@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"zincsearch/zincsearch"
+)
+
+// 文档写入内存索引，返回Memory用来检查写入结果
+func setupMemoryIndexer(t *testing.T)*zincsearch.Memory{
+	getZincClient()
+	memory := zincsearch.NewMemory()
+	old_indexer, old_searcher := zincIndexer, zincSearcher
+	zincIndexer, zincSearcher = memory, memory
+	t.Cleanup(func(){ zincIndexer, zincSearcher = old_indexer, old_searcher })
+	return memory
+}
+
+func getMemoryHit(t *testing.T, memory *zincsearch.Memory, index string, id string)zincsearch.Hit{
+	result, err := memory.Query(context.Background(), index, &zincsearch.QueryRequest{Query: zincsearch.Term("_id", id), Size: 1})
+	if err != nil{
+		t.Fatal(err)
+	}
+	if len(result.Hits.Hits) != 1{
+		t.Fatalf("document %s not found", id)
+	}
+	return result.Hits.Hits[0]
+}
+
+func TestInsertYuniJs(t *testing.T){
+	memory := setupMemoryIndexer(t)
+	memory.SetAlias("search_qm", "search_qm_1")
+	if err := insertYuniJs(0, "yuni", "search_qm 12345_xiaomei 小美 qm 天河 学生 兼职"); err != nil{
+		t.Fatal(err)
+	}
+	hit := getMemoryHit(t, memory, "search_qm_1", "12345_xiaomei")
+	doc := zincsearch.DocumentFromHit(hit)
+	if doc.Title != "天河小美的与你" || doc.JsName != "小美" || doc.Tags != "#学生#兼职" || doc.ContactType != "yuni"{
+		t.Fatalf("doc %+v", doc)
+	}
+	if doc.CreatedAt == 0 || hit.Source["title_pinyin"] == nil{
+		t.Fatalf("created_at or pinyin missing: %+v", doc)
+	}
+	// 写入后能按关键词搜到
+	result, err := memory.Query(context.Background(), "search_qm", &zincsearch.QueryRequest{Query: zincsearch.KeywordQuery("小美"), Size: 10})
+	if err != nil{
+		t.Fatal(err)
+	}
+	if len(result.Hits.Hits) != 1{
+		t.Fatalf("hits %v", result.Hits.Hits)
+	}
+}
+
+func TestMergeDuplicateFields(t *testing.T){
+	memory := setupMemoryIndexer(t)
+	primary := zincsearch.Document{Title: "天河小美", JsName: "小美", Tags: "#学生", UserCount: 500, CreatedAt: 200, RefreshedAt: 300}
+	memory.UpdateDocument("search_qm", "xiaomei", primary)
+	docs := map[string]zincsearch.Document{
+		"xiaomei": primary,
+		"xiaomei2": {Title: "小美2", Tags: "#学生#兼职", CreatedAt: 100},
+	}
+	fields := mergeFields(primary, []string{"xiaomei2", "gone"}, docs)
+	if fields["tags"] != "#学生#兼职" || fields["created_at"] != int64(100){
+		t.Fatalf("fields %v", fields)
+	}
+	if err := getZincIndexer().UpdateFields("search_qm", "xiaomei", fields); err != nil{
+		t.Fatal(err)
+	}
+	// 部分更新，人数、刷新时间等其它字段不变
+	doc := zincsearch.DocumentFromHit(getMemoryHit(t, memory, "search_qm", "xiaomei"))
+	if doc.Tags != "#学生#兼职" || doc.CreatedAt != 100 || doc.UserCount != 500 || doc.RefreshedAt != 300 || doc.Title != "天河小美"{
+		t.Fatalf("doc %+v", doc)
+	}
+}
+
+func TestSetDocumentHidden(t *testing.T){
+	memory := setupMemoryIndexer(t)
+	memory.UpdateDocument("search_qm", "xiaomei", zincsearch.Document{Title: "天河小美", UserCount: 500})
+	if err := setDocumentHidden(getZincIndexer(), "search_qm", "xiaomei", true); err != nil{
+		t.Fatal(err)
+	}
+	doc := zincsearch.DocumentFromHit(getMemoryHit(t, memory, "search_qm", "xiaomei"))
+	if !doc.Hidden || doc.Title != "天河小美" || doc.UserCount != 500{
+		t.Fatalf("doc %+v", doc)
+	}
+	if err := setDocumentHidden(getZincIndexer(), "search_qm", "gone", true); err == nil{
+		t.Fatal("expected not found")
+	}
+}
+
+func TestInsertYuniJsKeepsCreatedAt(t *testing.T){
+	memory := setupMemoryIndexer(t)
+	memory.UpdateDocument("search_qm", "12345_xiaomei", zincsearch.Document{Title: "旧标题", CreatedAt: 100})
+	if err := insertYuniJs(0, "yuni", "search_qm 12345_xiaomei 小美 qm 天河 学生"); err != nil{
+		t.Fatal(err)
+	}
+	doc := zincsearch.DocumentFromHit(getMemoryHit(t, memory, "search_qm", "12345_xiaomei"))
+	if doc.CreatedAt != 100 || doc.Title != "天河小美的与你"{
+		t.Fatalf("doc %+v", doc)
+	}
+}
+
+func TestRefreshFieldsKeepOtherFields(t *testing.T){
+	memory := setupMemoryIndexer(t)
+	memory.UpdateDocument("search_qm", "xiaomei", zincsearch.Document{Title: "旧标题", UserCount: 10, Tags: "qm 学生", Hidden: true, CreatedAt: 100})
+	if err := memory.UpdateFields("search_qm", "xiaomei", refreshFields("天河小美", 500, 200)); err != nil{
+		t.Fatal(err)
+	}
+	hit := getMemoryHit(t, memory, "search_qm", "xiaomei")
+	doc := zincsearch.DocumentFromHit(hit)
+	if doc.Title != "天河小美" || doc.UserCount != 500 || doc.RefreshedAt != 200{
+		t.Fatalf("refreshed fields not written: %+v", doc)
+	}
+	// 扫描后校验设置的hidden、合并的tags不能被刷新覆盖
+	if !doc.Hidden || doc.Tags != "qm 学生" || doc.CreatedAt != 100{
+		t.Fatalf("other fields overwritten: %+v", doc)
+	}
+	full, initials := zincsearch.ToPinyin("天河小美")
+	if hit.Source["title_pinyin"] != full || hit.Source["title_initials"] != initials{
+		t.Fatalf("pinyin %v %v", hit.Source["title_pinyin"], hit.Source["title_initials"])
+	}
+}
@@ -0,0 +1,38 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// 匀速限流，调用telegram接口前先Wait，多个goroutine共用一个
+type RateLimiter struct {
+	interval time.Duration
+	next     time.Time
+	mutex    sync.Mutex
+}
+
+// 每秒最多rate次，rate<=0时不限流
+func NewRateLimiter(rate int) *RateLimiter {
+	r := &RateLimiter{}
+	if rate > 0 {
+		r.interval = time.Second / time.Duration(rate)
+	}
+	return r
+}
+
+// 阻塞到可以发下一次请求
+func (r *RateLimiter) Wait() {
+	if r.interval <= 0 {
+		return
+	}
+	r.mutex.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	wait := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mutex.Unlock()
+	time.Sleep(wait)
+}
@@ -219,6 +219,38 @@ type AnswerCallbackQueryConfig struct {
 	Response bool `json:"result,omitempty"`
 }
 
+// 发送付款单，telegram stars付款时currency为XTR，provider_token留空
+type SendInvoiceConfig struct {
+	ChatID any `json:"chat_id,omitempty"`
+	Title string `json:"title"`
+	Description string `json:"description"`
+	Payload string `json:"payload"`
+	ProviderToken string `json:"provider_token,omitempty"`
+	Currency string `json:"currency"`
+	Prices []LabeledPrice `json:"prices"`
+
+	Response Message `json:"result,omitempty"`
+}
+
+// 付款前确认，收到pre_checkout_query后10秒内必须回复
+type AnswerPreCheckoutQueryConfig struct {
+	PreCheckoutQueryID string `json:"pre_checkout_query_id"`
+	OK bool `json:"ok"`
+	ErrorMessage string `json:"error_message,omitempty"`
+
+	Response bool `json:"result,omitempty"`
+}
+
+type AnswerInlineQueryConfig struct {
+	InlineQueryID string `json:"inline_query_id"`
+	Results []any `json:"results"`
+	CacheTime int `json:"cache_time,omitempty"`
+	IsPersonal bool `json:"is_personal,omitempty"`
+	NextOffset string `json:"next_offset,omitempty"`
+
+	Response bool `json:"result,omitempty"`
+}
+
 type DeleteMessageConfig struct {
 	ChatID int64 `json:"chat_id"`
 	MessageID int `json:"message_id"`
@@ -276,14 +308,113 @@ type SetMyCommandsConfig struct{
 type AdFeed struct {
 	Title string `json:"title,omitempty"`
 	ChatID string `json:"chat_id,omitempty"`
+	// 权重，广告多于广告位时按权重轮播
 	Order int `json:"order,omitempty"`
+	// 结束时间
 	TS int64 `json:"ts,omitempty"`
+	// 开始时间，0表示立即开始
+	Start int64 `json:"start,omitempty"`
+	// 每天最多展示次数，0不限制
+	DailyCap int `json:"daily_cap,omitempty"`
+	// 关键词广告购买的关键词
+	Keyword string `json:"keyword,omitempty"`
+}
+
+// 用户在search_bot里自助购买的广告订单
+type AdOrder struct{
+	ID string `json:"id"`
+	UserID int64 `json:"user_id"`
+	// adfeed全局推广 topfeed关键词推广
+	Kind string `json:"kind"`
+	Keyword string `json:"keyword,omitempty"`
+	// 推广的频道用户名，不带@
+	ChatID string `json:"chat_id,omitempty"`
+	Title string `json:"title,omitempty"`
+	Days int `json:"days,omitempty"`
+	// 价格，单位stars
+	Amount int `json:"amount,omitempty"`
+	// 当前步骤: slot keyword channel days confirm invoiced paid
+	Step string `json:"step"`
+	ChargeID string `json:"charge_id,omitempty"`
+	CreatedAt int64 `json:"created_at"`
+	PaidAt int64 `json:"paid_at,omitempty"`
+}
+
+// 用户在kkoa_bot用adddoc提交的频道，管理员审核通过后才写入索引
+type Submission struct{
+	// 频道用户名，不带@
+	ChatID string `json:"chat_id"`
+	Title string `json:"title,omitempty"`
+	UserCount int `json:"user_count,omitempty"`
+	JsName string `json:"js_name"`
+	Location string `json:"location"`
+	Tags []string `json:"tags,omitempty"`
+	// 提交的用户
+	UserID int64 `json:"user_id"`
+	UserName string `json:"user_name,omitempty"`
+	CreatedAt int64 `json:"created_at"`
+}
+
+// kkoa_bot找到的一组疑似重复文档，第一个是合并时保留的主文档
+type DuplicateGroup struct{
+	Index string `json:"index"`
+	IDs []string `json:"ids"`
 }
 
 type AdFeedList struct{
 	Feeds []AdFeed `json:"feeds,omitempty"`
 }
 
+// 搜索日志，search_bot写入redis stream search_log
+type SearchLog struct{
+	// 用户id加盐后的hash，不保存原始id
+	UserHash string `json:"user_hash"`
+	// 规范化后的搜索语句
+	Query string `json:"query"`
+	Hits int `json:"hits"`
+	Page int `json:"page"`
+	LatencyMs int64 `json:"latency_ms"`
+	// 点击的文档id，只有click事件有
+	Clicked string `json:"clicked,omitempty"`
+	// message page inline click
+	Source string `json:"source"`
+	TS int64 `json:"ts"`
+}
+
+// 点击跳转的短链接，redirect_server按短id查到后记录点击再跳转
+type RedirectLink struct{
+	URL string `json:"url"`
+	// doc adfeed topfeed
+	Kind string `json:"kind"`
+	// 文档id或者广告的chatid
+	Target string `json:"target"`
+	// 在结果页里的位置，从1开始
+	Position int `json:"position"`
+}
+
+// 用户订阅的搜索
+type SavedSearch struct{
+	// 规范化后的搜索语句
+	Query string `json:"query"`
+	CreatedAt int64 `json:"created_at"`
+	// 已经推送到的文档写入时间，只推送之后写入的文档
+	LastNotified int64 `json:"last_notified"`
+}
+
+type SavedSearchList struct{
+	Searches []SavedSearch `json:"searches,omitempty"`
+}
+
+// 同义词表，每组里的词互为同义词
+type SynonymList struct{
+	Groups [][]string `json:"groups,omitempty"`
+}
+
+// 管理员设置的搜索排序加权，key是文档id
+type RankBoostList struct{
+	Boosts map[string]float64 `json:"boosts,omitempty"`
+}
+
 type BotKey struct{
 	Key string `json:"key"`
 	RetryTime int64 `json:"retry_time"`
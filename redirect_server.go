@@ -0,0 +1,98 @@
+package main
+
+import (
+	"zincsearch/lib"
+	"zincsearch/model"
+	"zincsearch/db"
+	"net/http"
+	"strings"
+	"strconv"
+	"os"
+	"bufio"
+	"io"
+	"time"
+)
+
+// 点击跳转服务，search_bot开启redirect_base_url后结果链接指向这里
+// /r/{短id} 查redis里的redirect_link_{短id}，记录点击后302跳转到t.me
+var g_sListen = ":8090"
+
+func InitConfig(){
+	if len(os.Args) != 2 {
+		lib.XLogErr("invalid usage! example: ./program config_file")
+		panic("invalid usage")
+	}
+	config_file := os.Args[1]
+	config, err := os.Open(config_file)
+	if err != nil {
+		lib.XLogErr("open config fail", config_file)
+		panic("load config error")
+	}
+	defer config.Close()
+
+	br := bufio.NewReader(config)
+	for {
+		a, _, c := br.ReadLine()
+		if c == io.EOF {
+			break
+		}
+		line := string(a)
+		idx := strings.Index(line, "=")
+		if idx == -1 {
+			lib.XLogErr("invalid config", line)
+			break
+		}
+		lib.XLogInfo("config line", line)
+		if line[0 : idx] == "listen" {
+			g_sListen = line[idx + 1:]
+		}
+	}
+}
+
+func main() {
+	InitConfig()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/r/", handleRedirect)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request){
+		w.Write([]byte("ok"))
+	})
+	server := &http.Server{
+		Addr: g_sListen,
+		Handler: mux,
+		ReadTimeout: 5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+	lib.XLogInfo("listen", g_sListen)
+	if err := server.ListenAndServe(); err != nil{
+		lib.XLogErr("ListenAndServe", err)
+	}
+}
+
+func handleRedirect(w http.ResponseWriter, r *http.Request){
+	id := strings.TrimPrefix(r.URL.Path, "/r/")
+	if id == "" || strings.Contains(id, "/"){
+		http.NotFound(w, r)
+		return
+	}
+	var link model.RedirectLink
+	if err := db.GetStruct("redirect_link_" + id, &link); err != nil || !strings.HasPrefix(link.URL, "https://t.me/"){
+		lib.XLogErr("unknown link", id, err)
+		http.NotFound(w, r)
+		return
+	}
+	go countClick(link)
+	http.Redirect(w, r, link.URL, http.StatusFound)
+}
+
+// 按天统计每个文档、广告的点击数，以及每个位置的点击数
+func countClick(link model.RedirectLink){
+	day := time.Now().Format("20060102")
+	keys := []string{"click_stats_" + link.Kind + "_" + day, "click_stats_pos_click_" + day}
+	if err := db.ZIncrBy(keys[0], 1, link.Target); err != nil{
+		lib.XLogErr("count click", link, err)
+	}
+	db.ZIncrBy(keys[1], 1, link.Kind + ":" + strconv.Itoa(link.Position))
+	for _, key := range keys{
+		db.Expire(key, 30 * 24 * time.Hour)
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"errors"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"zincsearch/lib"
 	"zincsearch/model"
 	"zincsearch/db"
@@ -16,21 +20,107 @@ import (
 	"io"
 	"sync"
 	"sort"
+	"unicode"
 	"encoding/base64"
+	"context"
 )
 
 var g_sBotKey = ""
 var g_iPageCount = int(10)
+// 关键词太长时翻页按钮里只放hash，原文在redis里保存多久
+var g_pageQueryTTL = 24 * time.Hour
+// inline模式每页条数和客户端缓存时间(秒)
+var g_iInlinePageCount = int(20)
+var g_iInlineCacheTime = int(30)
+// freq_check=1时开启频率限制
 var g_bFreqCheck = false
+// 每个用户窗口内最多搜索次数，同一个搜索语句窗口内最多次数
+var g_iRateUserLimit = int64(20)
+var g_rateUserWindow = 60 * time.Second
+var g_iRateQueryLimit = int64(5)
+var g_rateQueryWindow = 60 * time.Second
+// inline模式边输入边查询，单独计数，窗口内最多查询次数
+var g_iRateInlineLimit = int64(60)
+var g_rateInlineWindow = 60 * time.Second
+// 超限后的冷却时间，1小时内每次超限翻倍，最长g_rateCooldownMax
+var g_rateCooldown = 30 * time.Second
+var g_rateCooldownMax = time.Hour
+// 同时请求zincsearch的搜索数
+var g_iSearchConcurrency = int(20)
+var g_searchSem chan struct{}
+var errSearchBusy = errors.New("search busy")
+// 管理员用户名，逗号分隔，可以用/explain查看排序原因
+var g_mapAdmins = map[string]bool{}
+// 排序权重，前g_iRankWindow条结果取回后重新排序，<=0时不重排
+var g_rankWeights = zincsearch.DefaultRankWeights()
+var g_iRankWindow = int(100)
+var g_mapRankBoosts = map[string]float64{}
+// 广告位数量，有效广告多于广告位时轮播
+var g_iAdFeedSlots = int(3)
+var g_iTopFeedSlots = int(2)
+// 自助购买推广每天的价格(stars)、可选天数、购买后的轮播权重
+var g_iAdFeedPrice = int(100)
+var g_iTopFeedPrice = int(50)
+var g_adDays = []int{7, 30, 90}
+var g_iAdOrderWeight = int(1)
+// 有人购买推广后通知的管理员会话，0不通知
+var g_iAdNotifyChat = int64(0)
+// 测试用，开启后管理员购买推广不用真正付款
+var g_bFakePayment = false
+// 群组里 /s 关键词、@bot 关键词 或者 group_prefix开头的消息才搜索
+// group_prefix需要在BotFather关闭privacy mode才能收到
+var g_sGroupPrefix = ""
+var g_sBotUserName = ""
+// 群组里的结果消息多久后自动删除，0不删除
+var g_groupDeleteDelay = 120 * time.Second
+// 每个用户最多订阅几个搜索，kkoa_bot写入文档后安静多久算一批写入结束
+var g_iSubscribeLimit = int(5)
+var g_iSubscribeQuiet = int64(120)
+// 订阅推送每条消息的结果数和每次最多推送几条消息，剩下的下一批写入后再推送
+var g_iSubscribePageSize = int(20)
+var g_iSubscribeMaxPages = int(3)
+// 每个用户一把锁，订阅列表的读改写按用户串行
+var g_subscribeLocks sync.Map
+// kkoa_bot维护的同义词表
+var g_synonyms = zincsearch.NewSynonyms(nil)
+// 搜索结果里同一个人的多个文档只显示排名最高的，管理员确认不是重复的文档id不合并
+// 按艺名+地区判断，常见艺名在同一个区会误合并不同的人，默认关闭
+var g_bCollapseDuplicates = false
+var g_mapDedupKeep = map[string]bool{}
+// 搜索结果缓存，redis和进程内LRU两级，ttl<=0时不缓存
+// key里带缓存版本号，kkoa_bot写文档或者修改广告时版本号加一，旧缓存不再命中
+var g_cacheTTL = 300 * time.Second
+var g_iCacheSize = int(2000)
+var g_searchCache *lib.LRU
+// 搜索日志里用户id的hash盐
+var g_sAnalyticsSalt = ""
+// 点击跳转服务的地址，如 https://r.example.com/r/ ，为空时直接链接到t.me
+var g_sRedirectBaseURL = ""
+// 无结果时的纠错词典，定时从索引重建
+var g_suggestDict = zincsearch.NewDictionary()
+var g_suggestRefresh = 30 * time.Minute
 var zincIndexName = ""
 var zincSearchURL = ""
 var zincSearchUser = ""
 var zincSearchPasswd = ""
+var zincSearchTimeout = 3 * time.Second
+var zincClient *zincsearch.Client
+// 搜索走接口，search_bot_test.go里换成内存索引
+var zincSearcher zincsearch.Searcher
 var tb model.TBot
+// index_name可以配置成别名，重建索引后别名切换，这里定时解析出实际索引
+var g_sSearchIndex = ""
 var(
+	g_searchindex_mutex sync.RWMutex
 	g_chatmembercount_mutex sync.RWMutex
 	g_chatinfo_mutex sync.RWMutex
 	g_adfeedtitle_mutex sync.RWMutex
+	g_rankboost_mutex sync.RWMutex
+	g_suggestdict_mutex sync.RWMutex
+	g_synonyms_mutex sync.RWMutex
+	g_dedupkeep_mutex sync.RWMutex
+	g_cacheversion_mutex sync.Mutex
+	g_adorder_mutex sync.Mutex
 )
 
 func InitConfig(){
@@ -65,8 +155,42 @@ func InitConfig(){
 			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil{
 				g_iPageCount = tmp
 			}
+		}else if line[0 : idx] == "inline_page_count"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil && tmp > 0 && tmp <= 50{
+				g_iInlinePageCount = tmp
+			}
+		}else if line[0 : idx] == "inline_cache_time"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil && tmp >= 0{
+				g_iInlineCacheTime = tmp
+			}
 		}else if line[0: idx] == "freq_check"{
 			g_bFreqCheck = line[idx + 1:] == "1"
+		}else if strings.HasPrefix(line[0:idx], "rate_") || line[0:idx] == "search_concurrency"{
+			tmp, err := strconv.Atoi(line[idx + 1:])
+			if err != nil || tmp <= 0{
+				lib.XLogErr("invalid rate config", line)
+				continue
+			}
+			switch line[0:idx]{
+			case "rate_user_limit":
+				g_iRateUserLimit = int64(tmp)
+			case "rate_user_window_s":
+				g_rateUserWindow = time.Duration(tmp) * time.Second
+			case "rate_query_limit":
+				g_iRateQueryLimit = int64(tmp)
+			case "rate_query_window_s":
+				g_rateQueryWindow = time.Duration(tmp) * time.Second
+			case "rate_inline_limit":
+				g_iRateInlineLimit = int64(tmp)
+			case "rate_inline_window_s":
+				g_rateInlineWindow = time.Duration(tmp) * time.Second
+			case "rate_cooldown_s":
+				g_rateCooldown = time.Duration(tmp) * time.Second
+			case "rate_cooldown_max_s":
+				g_rateCooldownMax = time.Duration(tmp) * time.Second
+			case "search_concurrency":
+				g_iSearchConcurrency = tmp
+			}
 		}else if line[0: idx] == "index_name"{
 			zincIndexName = line[idx + 1:]
 		}else if line[0: idx] == "zincsearch_url_prefix"{
@@ -75,6 +199,110 @@ func InitConfig(){
 			zincSearchUser = line[idx + 1:]
 		}else if line[0:idx] == "zincsearch_passwd"{
 			zincSearchPasswd = line[idx + 1:]
+		}else if line[0:idx] == "admin"{
+			for _, name := range strings.Split(line[idx + 1:], ","){
+				if name = strings.TrimSpace(name); name != ""{
+					g_mapAdmins[name] = true
+				}
+			}
+		}else if line[0:idx] == "adfeed_slots"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil{
+				g_iAdFeedSlots = tmp
+			}
+		}else if line[0:idx] == "topfeed_slots"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil{
+				g_iTopFeedSlots = tmp
+			}
+		}else if line[0:idx] == "ad_price_adfeed"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil && tmp > 0{
+				g_iAdFeedPrice = tmp
+			}
+		}else if line[0:idx] == "ad_price_topfeed"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil && tmp > 0{
+				g_iTopFeedPrice = tmp
+			}
+		}else if line[0:idx] == "ad_days"{
+			var days []int
+			for _, v := range strings.Split(line[idx + 1:], ","){
+				if tmp, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && tmp > 0{
+					days = append(days, tmp)
+				}
+			}
+			if len(days) > 0{
+				g_adDays = days
+			}
+		}else if line[0:idx] == "ad_order"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil{
+				g_iAdOrderWeight = tmp
+			}
+		}else if line[0:idx] == "ad_notify_chat"{
+			if tmp, err := strconv.ParseInt(line[idx + 1:], 10, 64); err == nil{
+				g_iAdNotifyChat = tmp
+			}
+		}else if line[0:idx] == "collapse_duplicates"{
+			g_bCollapseDuplicates = line[idx + 1:] != "0"
+		}else if line[0:idx] == "fake_payment"{
+			g_bFakePayment = line[idx + 1:] == "1"
+		}else if line[0:idx] == "group_prefix"{
+			g_sGroupPrefix = strings.TrimSpace(line[idx + 1:])
+		}else if line[0:idx] == "group_delete_s"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil && tmp >= 0{
+				g_groupDeleteDelay = time.Duration(tmp) * time.Second
+			}
+		}else if line[0:idx] == "subscribe_limit"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil{
+				g_iSubscribeLimit = tmp
+			}
+		}else if line[0:idx] == "subscribe_quiet_s"{
+			if tmp, err := strconv.ParseInt(line[idx + 1:], 10, 64); err == nil{
+				g_iSubscribeQuiet = tmp
+			}
+		}else if line[0:idx] == "subscribe_max_pages"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil && tmp > 0{
+				g_iSubscribeMaxPages = tmp
+			}
+		}else if line[0:idx] == "suggest_refresh_min"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil && tmp > 0{
+				g_suggestRefresh = time.Duration(tmp) * time.Minute
+			}
+		}else if line[0:idx] == "redirect_base_url"{
+			g_sRedirectBaseURL = line[idx + 1:]
+		}else if line[0:idx] == "analytics_salt"{
+			g_sAnalyticsSalt = line[idx + 1:]
+		}else if line[0:idx] == "cache_ttl_s"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil{
+				g_cacheTTL = time.Duration(tmp) * time.Second
+			}
+		}else if line[0:idx] == "cache_size"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil{
+				g_iCacheSize = tmp
+			}
+		}else if line[0:idx] == "rank_window"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil{
+				g_iRankWindow = tmp
+			}
+		}else if strings.HasPrefix(line[0:idx], "rank_"){
+			tmp, err := strconv.ParseFloat(line[idx + 1:], 64)
+			if err != nil{
+				lib.XLogErr("invalid rank config", line)
+				continue
+			}
+			switch line[0:idx]{
+			case "rank_weight_score":
+				g_rankWeights.Score = tmp
+			case "rank_weight_members":
+				g_rankWeights.Members = tmp
+			case "rank_weight_freshness":
+				g_rankWeights.Freshness = tmp
+			case "rank_weight_boost":
+				g_rankWeights.Boost = tmp
+			case "rank_freshness_days":
+				g_rankWeights.FreshnessHalfLife = time.Duration(tmp * 24 * float64(time.Hour))
+			}
+		}else if line[0:idx] == "zincsearch_timeout_ms"{
+			if tmp, err := strconv.Atoi(line[idx + 1:]); err == nil && tmp > 0{
+				zincSearchTimeout = time.Duration(tmp) * time.Millisecond
+			}
 		}
 	}
 }
@@ -82,6 +310,19 @@ func InitConfig(){
 func main() {
 	InitConfig()
 	tb.BotKey = g_sBotKey
+	// 单次请求的超时比整个搜索的超时短，zincsearch卡住时由客户端超时计入熔断
+	client_config := zincsearch.DefaultClientConfig()
+	client_config.Timeout = zincSearchTimeout * 2 / 3
+	zincClient = zincsearch.NewClientWithConfig(zincSearchURL, zincSearchUser, zincSearchPasswd, client_config)
+	zincSearcher = zincClient
+	g_searchCache = lib.NewLRU(g_iCacheSize, g_cacheTTL)
+	g_searchSem = make(chan struct{}, g_iSearchConcurrency)
+
+	me := model.GetMeConfig{}
+	if err := tb.Call(&me); err != nil{
+		lib.XLogErr("getMe", err)
+	}
+	g_sBotUserName = me.Response.UserName
 
 	config := model.UpdateConfig{}
 	config.Offset = 0
@@ -89,6 +330,27 @@ func main() {
 	config.Timeout = 10
 	ch := tb.GetUpdateChan(&config)
 
+	refreshSearchIndex()
+	refreshRankBoosts()
+	refreshSynonyms()
+	refreshDedupKeep()
+	go func(){
+		for range time.Tick(30 * time.Second){
+			refreshSearchIndex()
+			refreshRankBoosts()
+			refreshSynonyms()
+			refreshDedupKeep()
+		}
+	}()
+
+	go runSubscriptions()
+	go func(){
+		refreshSuggestDict()
+		for range time.Tick(g_suggestRefresh){
+			refreshSuggestDict()
+		}
+	}()
+
 	for update := range ch {
 		if update.EditedMessage != nil || update.ChannelPost != nil || update.EditedChannelPost != nil{
 			lib.XLogInfo("skip", update.UpdateID)
@@ -96,12 +358,203 @@ func main() {
 		}
 		if update.CallbackQuery != nil{
 			go handleCallback(update.UpdateID, update.CallbackQuery)
+		}else if update.InlineQuery != nil{
+			go handleInlineQuery(update.UpdateID, update.InlineQuery)
+		}else if update.ChosenInlineResult != nil{
+			go handleChosenInlineResult(update.ChosenInlineResult)
+		}else if update.PreCheckoutQuery != nil{
+			go handlePreCheckoutQuery(update.PreCheckoutQuery)
 		}else if update.Message != nil {
 			go handleMessage(update.UpdateID, update.Message)
 		}
 	}
 }
 
+func refreshSearchIndex(){
+	index, err := zincClient.ResolveIndex(zincIndexName)
+	if err != nil{
+		// 解析失败时继续用上一次的索引
+		lib.XLogErr("resolve search index", zincIndexName, err)
+		return
+	}
+	g_searchindex_mutex.Lock()
+	if g_sSearchIndex != index{
+		lib.XLogInfo("search index", zincIndexName, index)
+		g_sSearchIndex = index
+	}
+	g_searchindex_mutex.Unlock()
+}
+
+func getSearchIndex()string{
+	g_searchindex_mutex.RLock()
+	defer g_searchindex_mutex.RUnlock()
+	if g_sSearchIndex == ""{
+		return zincIndexName
+	}
+	return g_sSearchIndex
+}
+
+// kkoa_bot的set_boost写入redis，这里定时加载
+func refreshRankBoosts(){
+	var list model.RankBoostList
+	if err := db.GetStruct("zincsearch_bot_boosts", &list); err != nil{
+		return
+	}
+	if list.Boosts == nil{
+		list.Boosts = map[string]float64{}
+	}
+	g_rankboost_mutex.Lock()
+	g_mapRankBoosts = list.Boosts
+	g_rankboost_mutex.Unlock()
+}
+
+func getRankBoosts()map[string]float64{
+	g_rankboost_mutex.RLock()
+	defer g_rankboost_mutex.RUnlock()
+	return g_mapRankBoosts
+}
+
+// 遍历索引重建纠错词典
+func refreshSuggestDict(){
+	start := time.Now()
+	dict := zincsearch.NewDictionary()
+	err := zincClient.Scan(getSearchIndex(), 500, func(hits []zincsearch.Hit)error{
+		for _, hit := range hits{
+			dict.AddDocument(zincsearch.DocumentFromHit(hit))
+		}
+		return nil
+	})
+	if err != nil{
+		lib.XLogErr("refresh suggest dict", err)
+		return
+	}
+	g_suggestdict_mutex.Lock()
+	g_suggestDict = dict
+	g_suggestdict_mutex.Unlock()
+	lib.XLogInfo("suggest dict", dict.Len(), time.Since(start))
+}
+
+// 纠错后的搜索语句，callback_data最长64字节，放不下的丢掉
+func suggestQueries(keyword string)[]string{
+	g_suggestdict_mutex.RLock()
+	dict := g_suggestDict
+	g_suggestdict_mutex.RUnlock()
+	var result []string
+	for _, query := range dict.SuggestQuery(keyword, 3){
+		if len(suggestCallbackData(query)) <= 64{
+			result = append(result, query)
+		}
+	}
+	return result
+}
+
+func suggestCallbackData(query string)string{
+	return query + "$$" + strconv.Itoa(g_iPageCount) + "$$0"
+}
+
+// 翻页按钮的数据 关键词$$每页条数$$偏移，超过64字节时关键词换成~加hash，原文存在redis里
+func pageCallbackData(query string, from int)string{
+	suffix := "$$" + strconv.Itoa(g_iPageCount) + "$$" + strconv.Itoa(from)
+	if len(query) + len(suffix) <= 64{
+		return query + suffix
+	}
+	sum := sha1.Sum([]byte(query))
+	short := "~" + hex.EncodeToString(sum[:8])
+	if err := db.SetWithExpire(pageQueryKey(short), query, g_pageQueryTTL); err != nil{
+		lib.XLogErr("save page query", query, err)
+	}
+	return short + suffix
+}
+
+func pageQueryKey(short string)string{
+	return "zincsearch_bot_page_query_" + short
+}
+
+// 还原翻页按钮里的关键词，过期时返回false
+func pageQuery(keyword string)(string, bool){
+	if len(keyword) != 17 || keyword[0] != '~'{
+		return keyword, true
+	}
+	if _, err := hex.DecodeString(keyword[1:]); err != nil{
+		return keyword, true
+	}
+	query, err := db.Get(pageQueryKey(keyword))
+	if err != nil || query == ""{
+		return "", false
+	}
+	return query, true
+}
+
+// 每个纠错词一个按钮，点击后按新的词搜索第一页
+func suggestButtons(suggestions []string)[][]model.InlineKeyboardButton{
+	var rows [][]model.InlineKeyboardButton
+	for _, query := range suggestions{
+		data := suggestCallbackData(query)
+		rows = append(rows, []model.InlineKeyboardButton{{Text: "🔍 " + query, CallbackData: &data}})
+	}
+	return rows
+}
+
+func refreshSynonyms(){
+	var list model.SynonymList
+	if err := db.GetStruct("zincsearch_bot_synonyms", &list); err != nil{
+		return
+	}
+	synonyms := zincsearch.NewSynonyms(list.Groups)
+	g_synonyms_mutex.Lock()
+	g_synonyms = synonyms
+	g_synonyms_mutex.Unlock()
+}
+
+func getSynonyms()*zincsearch.Synonyms{
+	g_synonyms_mutex.RLock()
+	defer g_synonyms_mutex.RUnlock()
+	return g_synonyms
+}
+
+func refreshDedupKeep(){
+	ids, err := db.GetSetMembers("zincsearch_bot_dedup_keep")
+	if err != nil{
+		return
+	}
+	keep := make(map[string]bool, len(ids))
+	for _, id := range ids{
+		keep[id] = true
+	}
+	g_dedupkeep_mutex.Lock()
+	g_mapDedupKeep = keep
+	g_dedupkeep_mutex.Unlock()
+}
+
+// 按指纹去掉重复的文档，保留排在前面的，返回去掉的个数
+func collapseDuplicates(hits []zincsearch.RankedHit)([]zincsearch.RankedHit, int){
+	g_dedupkeep_mutex.RLock()
+	keep := g_mapDedupKeep
+	g_dedupkeep_mutex.RUnlock()
+	seen := make(map[string]bool)
+	result := make([]zincsearch.RankedHit, 0, len(hits))
+	for _, hit := range hits{
+		fp := zincsearch.Fingerprint(zincsearch.DocumentFromHit(hit.Hit))
+		if fp != "" && !keep[hit.ID]{
+			if seen[fp]{
+				continue
+			}
+			seen[fp] = true
+		}
+		result = append(result, hit)
+	}
+	return result, len(hits) - len(result)
+}
+
+// 排除kkoa_bot检测到链接失效后隐藏的文档
+func visibleQuery(query zincsearch.Query)zincsearch.Query{
+	bq := &zincsearch.BoolQuery{
+		Must: []zincsearch.Query{query},
+		MustNot: []zincsearch.Query{zincsearch.Term("hidden", true)},
+	}
+	return bq.Query()
+}
+
 func batchGetChatMemberCount(chatids []string)map[string]int{
 
 	mapID2Count := make(map[string]int, len(chatids))
@@ -160,6 +613,103 @@ func batchGetChatInfo(chatids []string)map[string]model.Chat{
 	return mapID2Chat
 }
 
+var g_sCacheVersion = ""
+// 每次搜索都要用版本号，读redis后在进程内缓存一小段时间
+var g_cacheVersionAt time.Time
+var g_cacheVersionInterval = time.Second
+
+// 缓存版本号，读取失败时按0处理
+// kkoa_bot修改加权、同义词后也会更新版本号，版本号变化时立即重新加载，不用等定时刷新
+// 读redis和重新加载时不持有锁，其它搜索继续用旧版本号，加载完再换成新版本号
+func getCacheVersion()string{
+	g_cacheversion_mutex.Lock()
+	current := g_sCacheVersion
+	if current != "" && time.Since(g_cacheVersionAt) < g_cacheVersionInterval{
+		g_cacheversion_mutex.Unlock()
+		return current
+	}
+	// 先占住这一轮，间隔内的其它请求不再读redis
+	g_cacheVersionAt = time.Now()
+	g_cacheversion_mutex.Unlock()
+	version, err := db.Get("zincsearch_bot_cache_version")
+	if err != nil{
+		version = "0"
+	}
+	if version != current{
+		refreshRankBoosts()
+		refreshSynonyms()
+		refreshDedupKeep()
+	}
+	g_cacheversion_mutex.Lock()
+	g_sCacheVersion = version
+	g_cacheversion_mutex.Unlock()
+	return version
+}
+
+// 进程内缓存，cache_ttl<=0时不读也不写
+func searchCacheGet(key string)(interface{}, bool){
+	if g_cacheTTL <= 0{
+		return nil, false
+	}
+	return g_searchCache.Get(key)
+}
+
+func searchCacheAdd(key string, value interface{}){
+	if g_cacheTTL > 0{
+		g_searchCache.Add(key, value)
+	}
+}
+
+// 缓存的一页搜索结果
+type searchPage struct{
+	Docs []zincsearch.Document `json:"docs,omitempty"`
+	Total int `json:"total"`
+}
+
+// 带缓存的搜索，key是规范化后的搜索语句+分页
+func cachedSearch(updateid int, version string, query string, from int, size int)([]zincsearch.Document, int, error){
+	if g_cacheTTL <= 0{
+		return searchIndex(updateid, query, from, size)
+	}
+	normalized := zincsearch.ParseQuery(query).String()
+	key := fmt.Sprintf("zincsearch_bot_cache_%s_%s_%d_%d_%s", version, getSearchIndex(), from, size, base64.StdEncoding.EncodeToString([]byte(normalized)))
+	if value, ok := searchCacheGet(key); ok{
+		page := value.(searchPage)
+		return page.Docs, page.Total, nil
+	}
+	var page searchPage
+	if err := db.GetStruct(key, &page); err == nil{
+		searchCacheAdd(key, page)
+		return page.Docs, page.Total, nil
+	}
+	docs, total, err := searchIndex(updateid, query, from, size)
+	if err != nil{
+		return docs, total, err
+	}
+	page = searchPage{Docs: docs, Total: total}
+	searchCacheAdd(key, page)
+	if err := db.SetStructWithExpire(key, page, g_cacheTTL); err != nil{
+		lib.XLogErr("cache search", key, err)
+	}
+	return docs, total, nil
+}
+
+// 广告列表只缓存在进程内，版本号变化后重新读redis
+func getCachedAdFeeds(version string, key string)model.AdFeedList{
+	if g_cacheTTL <= 0{
+		return getAdFeeds(key)
+	}
+	cache_key := "adfeeds_" + version + "_" + key
+	if value, ok := searchCacheGet(cache_key); ok{
+		// 调用方会原地排序，返回副本
+		list := value.(model.AdFeedList)
+		return model.AdFeedList{Feeds: append([]model.AdFeed(nil), list.Feeds...)}
+	}
+	list := getAdFeeds(key)
+	searchCacheAdd(cache_key, list)
+	return model.AdFeedList{Feeds: append([]model.AdFeed(nil), list.Feeds...)}
+}
+
 func getAdFeeds(key string)model.AdFeedList{
 	var feeds model.AdFeedList
 	if err := db.GetStruct(key, &feeds); err != nil{
@@ -168,7 +718,9 @@ func getAdFeeds(key string)model.AdFeedList{
 	return feeds
 }
 
-func fillEmtities(updateid int, keyword string, from int)(string, []model.MessageEntity){
+// source是搜索日志里的来源: message page
+// 第三个返回值是附加的按钮: 没有结果时是纠错后的搜索语句，有结果时是每条结果的详情
+func fillEmtities(updateid int, userid int64, source string, keyword string, from int)(string, []model.MessageEntity, [][]model.InlineKeyboardButton){
 	var entities []model.MessageEntity
 	var ad_chatids []string
 	var top_chatids []string
@@ -178,36 +730,47 @@ func fillEmtities(updateid int, keyword string, from int)(string, []model.Messag
 	var wg sync.WaitGroup
 
 	var total int
+	var search_err error
 
 	msg_content := ""
+	version := getCacheVersion()
+	now := time.Now()
+	impressions := getAdImpressions(now)
+	var campaigns []string
+	var campaigns_mutex sync.Mutex
 
 	wg.Add(1)
 	go func(){
 		defer wg.Done()
-		result, count, err := searchIndex(updateid, keyword, from, g_iPageCount)
+		start := time.Now()
+		result, count, err := cachedSearch(updateid, version, keyword, from, g_iPageCount)
 		if err != nil {
 			lib.XLogErr("searchindex", updateid, keyword, from, g_iPageCount)
+			search_err = err
 			return
 		}
 		total = count
 		doc_list = result
+		go logSearch(model.SearchLog{
+			UserHash: hashUser(userid),
+			Query: zincsearch.ParseQuery(keyword).String(),
+			Hits: count,
+			Page: from / g_iPageCount,
+			LatencyMs: time.Since(start).Milliseconds(),
+			Source: source,
+		})
 	}()
 
 	wg.Add(1)
 	go func(){
 		defer wg.Done()
-		list := getAdFeeds("zincsearch_bot_adfeeds")
-		feeds := list.Feeds
-		sort.SliceStable(feeds, func(i, j int) bool {
-			return feeds[j].Order < feeds[i].Order
-		})
-		now := time.Now().Unix()
+		list := getCachedAdFeeds(version, "zincsearch_bot_adfeeds")
+		feeds := selectFeeds("adfeed", list.Feeds, g_iAdFeedSlots, impressions, now.Unix())
 		for _, v := range feeds{
-			if v.TS <= now{
-				lib.XLogErr("skip expire", v)
-				continue
-			}
 			ad_chatids = append(ad_chatids, v.ChatID)
+			campaigns_mutex.Lock()
+			campaigns = append(campaigns, adCampaignID("adfeed", v))
+			campaigns_mutex.Unlock()
 			g_adfeedtitle_mutex.Lock()
 			mapFeedTitle[v.ChatID] = v.Title
 			g_adfeedtitle_mutex.Unlock()
@@ -217,19 +780,12 @@ func fillEmtities(updateid int, keyword string, from int)(string, []model.Messag
 	wg.Add(1)
 	go func(){
 		defer wg.Done()
-		key := "zincsearch_bot_topfeeds_" + base64.StdEncoding.EncodeToString([]byte(keyword))
-		list := getAdFeeds(key)
-		feeds := list.Feeds
-		sort.SliceStable(feeds, func(i, j int) bool {
-			return feeds[j].Order < feeds[i].Order
-		})
-		now := time.Now().Unix()
+		feeds := matchTopFeeds(keyword, getAllTopFeeds(version), impressions, now.Unix())
 		for _, v := range feeds{
-			if v.TS <= now{
-				lib.XLogErr("skip expire", v)
-				continue
-			}
 			top_chatids = append(top_chatids, v.ChatID)
+			campaigns_mutex.Lock()
+			campaigns = append(campaigns, adCampaignID("topfeed", v))
+			campaigns_mutex.Unlock()
 			g_adfeedtitle_mutex.Lock()
 			mapFeedTitle[v.ChatID] = v.Title
 			g_adfeedtitle_mutex.Unlock()
@@ -243,14 +799,23 @@ func fillEmtities(updateid int, keyword string, from int)(string, []model.Messag
 		results = append(results, v)
 	}
 
+	if search_err != nil{
+		// zincsearch不可用时不能提示无结果，让用户稍后重试
+		return "搜索服务繁忙，请稍后重试", entities, nil
+	}
+
 	if len(doc_list) == 0{
 		lib.XLogErr("empty results", updateid, keyword)
-		if from == 0{
-			msg_content = "暂无搜索结果"
+		if from != 0{
+			return "暂无更多结果", entities, nil
+		}
+		suggestions := suggestQueries(keyword)
+		if len(suggestions) > 0{
+			msg_content = "暂无搜索结果，你是不是要找:\n" + strings.Join(suggestions, "\n")
 		}else{
-			msg_content = "暂无更多结果"
+			msg_content = "暂无搜索结果，发送 /help 查看搜索语法"
 		}
-		return msg_content, entities
+		return msg_content, entities, suggestButtons(suggestions)
 	}
 
 	top_des := "🪧  找老师搜索引擎说明\n"
@@ -264,10 +829,12 @@ func fillEmtities(updateid int, keyword string, from int)(string, []model.Messag
 	msg_content += top_des
 
 	// 广告
-	for _, id := range ad_chatids{
+	var positions []string
+	for i, id := range ad_chatids{
 		url := model.MessageEntity{}
 		url.Type = "text_link"
-		url.URL = fmt.Sprintf("https://t.me/%v", id)
+		url.URL = trackURL("adfeed", id, i + 1, fmt.Sprintf("https://t.me/%v", id))
+		positions = append(positions, fmt.Sprintf("adfeed:%d", i + 1))
 		url.Offset = GetUTF16Len(msg_content)
 		title := "🔥 " + mapFeedTitle[id]
 		url.Length = GetUTF16Len(title)
@@ -278,10 +845,11 @@ func fillEmtities(updateid int, keyword string, from int)(string, []model.Messag
 		msg_content += "🔥 推广位招租中"
 	}
 	// 买了搜索关键词的
-	for _, id := range top_chatids{
+	for i, id := range top_chatids{
 		url := model.MessageEntity{}
 		url.Type = "text_link"
-		url.URL = fmt.Sprintf("https://t.me/%v", id)
+		url.URL = trackURL("topfeed", id, i + 1, fmt.Sprintf("https://t.me/%v", id))
+		positions = append(positions, fmt.Sprintf("topfeed:%d", i + 1))
 		url.Offset = GetUTF16Len(msg_content)
 		title := "🔝 " + mapFeedTitle[id]
 		url.Length = GetUTF16Len(title)
@@ -297,17 +865,14 @@ func fillEmtities(updateid int, keyword string, from int)(string, []model.Messag
 	for _, doc := range doc_list {
 		url := model.MessageEntity{}
 		url.Type = "text_link"
-		url.URL = fmt.Sprintf("https://t.me/%v", doc.ID)
+		url.URL = trackURL("doc", doc.ID, count, fmt.Sprintf("https://t.me/%v", doc.ID))
+		positions = append(positions, fmt.Sprintf("doc:%d", count))
 		url.Offset = GetUTF16Len(msg_content)
 		logo := "📧"
 		if doc.ContactType == "yuni"{
 			logo = "🎭️"
 		}
-		str_user_count := strconv.Itoa(doc.UserCount)
-		if doc.UserCount > 1000{
-			str_user_count = strconv.Itoa(doc.UserCount / 1000) + "k"
-		}
-		title := strconv.Itoa(count) + ". " + logo + doc.Title + " - " + str_user_count +"人"
+		title := strconv.Itoa(count) + ". " + logo + doc.Title + " - " + formatUserCount(doc.UserCount) +"人"
 		url.Length = GetUTF16Len(title)
 		entities = append(entities, url)
 		msg_content += title + "\n"
@@ -317,7 +882,257 @@ func fillEmtities(updateid int, keyword string, from int)(string, []model.Messag
 	if len(doc_list) != 0{
 		msg_content += fmt.Sprintf("\n🔍 搜索结果（第 %d/%d 页）\n", from / 10 + 1, totalPages)
 	}
-	return msg_content, entities
+	if g_sRedirectBaseURL != ""{
+		go countImpressions(positions)
+	}
+	go countAdImpressions(now, campaigns)
+	return msg_content, entities, detailButtons(doc_list, from)
+}
+
+// 全部关键词广告，关键词 => 广告列表，版本号变化后重新扫描redis
+func getAllTopFeeds(version string)map[string][]model.AdFeed{
+	cache_key := "topfeeds_all_" + version
+	if value, ok := searchCacheGet(cache_key); ok{
+		return value.(map[string][]model.AdFeed)
+	}
+	prefix := "zincsearch_bot_topfeeds_"
+	all := make(map[string][]model.AdFeed)
+	cursor := uint64(0)
+	for{
+		keys, next, err := db.Search(prefix + "*", cursor, 100)
+		if err != nil{
+			lib.XLogErr("scan topfeeds", err)
+			break
+		}
+		for _, key := range keys{
+			data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(key, prefix))
+			if err != nil{
+				continue
+			}
+			keyword := strings.ToLower(strings.TrimSpace(string(data)))
+			for _, feed := range getAdFeeds(key).Feeds{
+				// 早期添加的关键词广告没有记录关键词
+				if feed.Keyword == ""{
+					feed.Keyword = string(data)
+				}
+				all[keyword] = append(all[keyword], feed)
+			}
+		}
+		if next == 0{
+			break
+		}
+		cursor = next
+	}
+	searchCacheAdd(cache_key, all)
+	return all
+}
+
+// 购买的关键词和搜索词的匹配程度，越小越好，-1表示不匹配
+const (
+	matchExact = iota
+	matchSynonym
+	matchPrefix
+	matchContains
+)
+
+func matchToken(bought string, tokens []string, synonyms *zincsearch.Synonyms)int{
+	best := -1
+	for _, token := range tokens{
+		level := -1
+		if token == bought{
+			level = matchExact
+		}else if containsString(synonyms.Expand(token), bought){
+			level = matchSynonym
+		}else if len([]rune(bought)) >= 2 && strings.HasPrefix(token, bought){
+			// 买了"天河"，搜"天河区"也展示
+			level = matchPrefix
+		}else if len([]rune(bought)) >= 2 && strings.Contains(token, bought){
+			// 中文搜索语句一般不带空格，买了"天河"，搜"广州天河区"也展示
+			level = matchContains
+		}
+		if level != -1 && (best == -1 || level < best){
+			best = level
+		}
+	}
+	return best
+}
+
+func containsString(list []string, value string)bool{
+	for _, v := range list{
+		if v == value{
+			return true
+		}
+	}
+	return false
+}
+
+// 关键词广告匹配: 搜索语句切成关键词和过滤条件的值，购买的关键词的每个词都要命中
+// 命中方式可以是相同、同义词、前缀或者包含；同一个频道买了多个关键词时只算Order最大的
+// 按Order从大到小选，Order相同时当天展示少的优先，最多g_iTopFeedSlots个
+func matchTopFeeds(keyword string, all map[string][]model.AdFeed, impressions map[string]float64, now int64)[]model.AdFeed{
+	parsed := zincsearch.ParseQuery(keyword)
+	var tokens []string
+	for _, v := range parsed.Keywords{
+		tokens = append(tokens, strings.ToLower(v))
+	}
+	for _, f := range parsed.Filters{
+		tokens = append(tokens, strings.ToLower(f.Value))
+	}
+	if len(tokens) == 0{
+		return nil
+	}
+	synonyms := getSynonyms()
+	type matched struct{
+		feed model.AdFeed
+		level int
+	}
+	best := make(map[string]matched)
+	for bought, feeds := range all{
+		level := -1
+		for _, word := range strings.Fields(bought){
+			l := matchToken(word, tokens, synonyms)
+			if l == -1{
+				level = -1
+				break
+			}
+			if l > level{
+				level = l
+			}
+		}
+		if level == -1{
+			continue
+		}
+		for _, feed := range selectFeeds("topfeed", feeds, 0, impressions, now){
+			old, ok := best[feed.ChatID]
+			if !ok || feed.Order > old.feed.Order || (feed.Order == old.feed.Order && level < old.level){
+				best[feed.ChatID] = matched{feed: feed, level: level}
+			}
+		}
+	}
+	var candidates []matched
+	for _, v := range best{
+		candidates = append(candidates, v)
+	}
+	sort.Slice(candidates, func(i, j int)bool{
+		a, b := candidates[i], candidates[j]
+		if a.feed.Order != b.feed.Order{
+			return a.feed.Order > b.feed.Order
+		}
+		ia := impressions[adCampaignID("topfeed", a.feed)]
+		ib := impressions[adCampaignID("topfeed", b.feed)]
+		if ia != ib{
+			return ia < ib
+		}
+		if a.level != b.level{
+			return a.level < b.level
+		}
+		return a.feed.ChatID < b.feed.ChatID
+	})
+	var result []model.AdFeed
+	for _, v := range candidates{
+		if g_iTopFeedSlots > 0 && len(result) >= g_iTopFeedSlots{
+			break
+		}
+		result = append(result, v.feed)
+	}
+	return result
+}
+
+// 广告活动的id，按这个id统计展示次数
+func adCampaignID(kind string, feed model.AdFeed)string{
+	if kind == "topfeed"{
+		return kind + ":" + feed.Keyword + ":" + feed.ChatID
+	}
+	return kind + ":" + feed.ChatID
+}
+
+// 当天每个广告活动的展示次数
+func getAdImpressions(now time.Time)map[string]float64{
+	result := make(map[string]float64)
+	members, err := db.ZRevRangeWithScores("zincsearch_bot_ad_impr_" + now.Format("20060102"), 0, -1)
+	if err != nil{
+		return result
+	}
+	for _, v := range members{
+		result[v.Member] = v.Score
+	}
+	return result
+}
+
+func countAdImpressions(now time.Time, campaigns []string){
+	if len(campaigns) == 0{
+		return
+	}
+	key := "zincsearch_bot_ad_impr_" + now.Format("20060102")
+	for _, id := range campaigns{
+		db.ZIncrBy(key, 1, id)
+	}
+	db.Expire(key, 90 * 24 * time.Hour)
+}
+
+// 选出本次展示的广告，过滤未开始、已结束和当天展示数达到上限的
+// 多于广告位时按 当天展示数/Order 从小到大选，Order越大分到的展示越多；展示时按Order从大到小
+func selectFeeds(kind string, feeds []model.AdFeed, slots int, impressions map[string]float64, now int64)[]model.AdFeed{
+	var active []model.AdFeed
+	for _, v := range feeds{
+		if v.Start > now || v.TS <= now{
+			continue
+		}
+		if v.DailyCap > 0 && impressions[adCampaignID(kind, v)] >= float64(v.DailyCap){
+			continue
+		}
+		active = append(active, v)
+	}
+	weight := func(v model.AdFeed)float64{
+		if v.Order <= 0{
+			return 1
+		}
+		return float64(v.Order)
+	}
+	if slots > 0 && len(active) > slots{
+		sort.SliceStable(active, func(i, j int)bool{
+			ri := impressions[adCampaignID(kind, active[i])] / weight(active[i])
+			rj := impressions[adCampaignID(kind, active[j])] / weight(active[j])
+			if ri != rj{
+				return ri < rj
+			}
+			return active[i].Order > active[j].Order
+		})
+		active = active[:slots]
+	}
+	sort.SliceStable(active, func(i, j int)bool{
+		return active[j].Order < active[i].Order
+	})
+	return active
+}
+
+// 开启跳转服务时把链接换成短链接，短id由类型+目标+位置生成，同一个位置的链接复用
+func trackURL(kind string, target string, position int, url string)string{
+	if g_sRedirectBaseURL == ""{
+		return url
+	}
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%s|%d", kind, target, position)))
+	id := hex.EncodeToString(sum[:6])
+	// 关闭缓存时每次都写redis
+	cache_key := "redirect_" + id
+	if _, ok := searchCacheGet(cache_key); !ok{
+		link := model.RedirectLink{URL: url, Kind: kind, Target: target, Position: position}
+		if err := db.SetStructWithExpire("redirect_link_" + id, link, 90 * 24 * time.Hour); err != nil{
+			lib.XLogErr("save redirect link", id, err)
+			return url
+		}
+		searchCacheAdd(cache_key, true)
+	}
+	return g_sRedirectBaseURL + id
+}
+
+// 按位置统计展示次数，和redirect_server统计的点击一起算点击率
+func countImpressions(positions []string){
+	key := "click_stats_pos_impr_" + time.Now().Format("20060102")
+	for _, position := range positions{
+		db.ZIncrBy(key, 1, position)
+	}
+	db.Expire(key, 30 * 24 * time.Hour)
 }
 
 func handleCallback(updateid int, callback *model.CallbackQuery){
@@ -327,11 +1142,36 @@ func handleCallback(updateid int, callback *model.CallbackQuery){
 		}
 	}()
 	values := strings.Split(callback.Data, "$$")
+	// 翻页是 关键词$$每页条数$$偏移，订阅是 sub$$搜索语句
+	if len(values) == 2 && values[0] == "sub"{
+		subscribe(callback, values[1])
+		return
+	}
+	if len(values) == 2 && values[0] == "unsub"{
+		unsubscribe(callback, values[1])
+		return
+	}
+	if len(values) == 2 && values[0] == "buy"{
+		handleAdOrderCallback(callback, values[1])
+		return
+	}
+	if len(values) == 2 && values[0] == "d"{
+		if !isResultOwner(callback){
+			answerCallback(callback, "只有发起搜索的人可以查看，发送 /s 关键词 自己搜索吧")
+			return
+		}
+		showDetail(callback, values[1])
+		return
+	}
 	if len(values) != 3{
 		lib.XLogErr("invalid callback", *callback)
 		return
 	}
-	keyword := values[0]
+	keyword, ok := pageQuery(values[0])
+	if !ok{
+		answerCallback(callback, "搜索已过期，请重新搜索")
+		return
+	}
 	from, err := strconv.Atoi(values[2])
 	if err != nil{
 		lib.XLogErr("invalid pagefrom", callback.Data)
@@ -340,29 +1180,37 @@ func handleCallback(updateid int, callback *model.CallbackQuery){
 	if from < 0 {
 		from = 0
 	}
+	if !isResultOwner(callback){
+		answerCallback(callback, "只有发起搜索的人可以翻页，发送 /s 关键词 自己搜索吧")
+		return
+	}
+	if ok, wait := checkRate(callback.From.ID, ""); !ok{
+		answerCallback(callback, rateLimitText(wait))
+		return
+	}
 
 	msg_config := model.EditMessageTextConfig{ChatID:callback.Message.Chat.ID, MessageID:callback.Message.MessageID}
 
-	msg_content, entities := fillEmtities(updateid, keyword, from)
+	msg_content, entities, rows := fillEmtities(updateid, callback.From.ID, "page", keyword, from)
 
 	msg_config.Entities = entities
 	msg_config.Text = msg_content
 	msg_config.LinkPreviewOption.IsDisable = true
 
 	last_page := model.InlineKeyboardButton{Text:"上一页"}
-	last_text := keyword + "$$" + strconv.Itoa(g_iPageCount) + "$$" + strconv.Itoa(from - g_iPageCount)
+	last_text := pageCallbackData(keyword, from - g_iPageCount)
 	last_page.CallbackData = &last_text
 	next_page := model.InlineKeyboardButton{Text:"下一页"}
-	next_text := keyword + "$$" + strconv.Itoa(g_iPageCount) + "$$" + strconv.Itoa(from + g_iPageCount)
+	next_text := pageCallbackData(keyword, from + g_iPageCount)
 	next_page.CallbackData = &next_text
 	var buttons []model.InlineKeyboardButton
 	buttons = append(buttons, last_page, next_page)
 
-	land_url := "tg://resolve?domain=kkhelper_bot"
-	feedback := model.InlineKeyboardButton{Text:"👣反馈搜索问题|添加频道|购买推广👣", URL: &land_url}
-
 	var markup model.InlineKeyboardMarkup
-	markup.InlineKeyboard = append(markup.InlineKeyboard, buttons, []model.InlineKeyboardButton{feedback})
+	markup.InlineKeyboard = append(rows, buttons, feedbackButtons())
+	if row := subscribeButton(keyword); row != nil{
+		markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+	}
 
 	msg_config.ReplyMarkup = markup
 
@@ -375,10 +1223,18 @@ func handleMessage(updateid int, msg *model.Message) {
 			lib.XLogErr("excption", err)
 		}
 	}()
+	if msg.SuccessfulPayment != nil{
+		handleSuccessfulPayment(msg)
+		return
+	}
 	if len(msg.Text) == 0{
 		lib.XLogErr("skip empty query", updateid)
 		return
 	}
+	if msg.Chat.Type != "private"{
+		handleGroupMessage(updateid, msg)
+		return
+	}
 	if msg.ForwardFrom != nil || msg.ForwardFromChat != nil || msg.ReplyToMessage != nil || msg.Animation != nil || msg.PremiumAnimation != nil || msg.Audio != nil || msg.Document != nil || len(msg.Photo) > 0 || msg.Sticker != nil || msg.Video != nil || msg.VideoNote != nil || msg.Voice != nil || len(msg.Caption) > 0 || msg.Contact != nil || msg.Dice != nil || msg.Game != nil || msg.Poll != nil || msg.Venue != nil || msg.Location != nil{
 		lib.XLogErr("skip invalid msg", updateid)
 		return
@@ -387,70 +1243,1331 @@ func handleMessage(updateid int, msg *model.Message) {
 	if query == "" {
 		return
 	}
-	sendSearchResults(updateid, msg.Chat.ID, msg.MessageID, 0, query)
-}
-
-// 搜索ZincSearch
-func searchIndex(updateid int, query string, page int, pageSize int) ([]zincsearch.Document, int, error) {
-	var result_list []zincsearch.Document
-	searchReq := &zincsearch.SearchRequest{
-		SearchType: "match",
-		Query: map[string]interface{}{
-			"term": query,
-		},
-		MaxResults: pageSize,
-		From: page,
-		SortFields: []string{"-_score"},
-		//SortFields: []string{"-user_count"},
+	if query == "/start" || query == "/help" || query == "帮助"{
+		replyText(msg.Chat.ID, msg.MessageID, zincsearch.QuerySyntaxHelp + "\n\n购买推广: /buy")
+		return
 	}
-	//lib.XLogInfo(updateid, searchReq)
-	client := zincsearch.NewClient("http://localhost:4080", zincSearchUser, zincSearchPasswd)
-	result, err := client.Search(zincIndexName, searchReq)
-	if err != nil {
-		lib.XLogErr("Search", err)
-		return result_list, 0, err
+	if query == "/subs"{
+		listSubscriptions(msg)
+		return
+	}
+	if strings.HasPrefix(query, "/explain") && msg.From != nil && g_mapAdmins[msg.From.UserName]{
+		explainSearch(updateid, msg, strings.TrimSpace(strings.TrimPrefix(query, "/explain")))
+		return
+	}
+	userid := msg.Chat.ID
+	if msg.From != nil{
+		userid = msg.From.ID
+	}
+	if query == "/buy" || query == "/start buy"{
+		startAdOrder(msg, userid)
+		return
+	}
+	// 购买推广时等待输入关键词或频道
+	if order, ok := pendingAdOrder(userid); ok{
+		handleAdOrderInput(msg, order, query)
+		return
+	}
+	if ok, wait := checkRate(userid, query); !ok{
+		replyText(msg.Chat.ID, msg.MessageID, rateLimitText(wait))
+		return
+	}
+	sendSearchResults(updateid, userid, msg, 0, query)
+}
+
+// 群组里的搜索，结果回复在原消息下，只有发起搜索的人能翻页
+func handleGroupMessage(updateid int, msg *model.Message){
+	if msg.From == nil || msg.ForwardFrom != nil || msg.ForwardFromChat != nil{
+		return
+	}
+	query, ok := groupQuery(strings.TrimSpace(msg.Text))
+	if !ok{
+		return
+	}
+	if query == "" || query == "/help"{
+		replyText(msg.Chat.ID, msg.MessageID, "用法: /s 关键词\n" + zincsearch.QuerySyntaxHelp)
+		return
+	}
+	if ok, wait := checkRate(msg.From.ID, query); !ok{
+		replyText(msg.Chat.ID, msg.MessageID, rateLimitText(wait))
+		return
+	}
+	sendSearchResults(updateid, msg.From.ID, msg, 0, query)
+}
+
+// 从群消息里取出搜索语句，不是发给bot的消息返回false
+func groupQuery(text string)(string, bool){
+	cmd, rest := text, ""
+	if idx := strings.IndexFunc(text, unicode.IsSpace); idx != -1{
+		cmd, rest = text[:idx], strings.TrimSpace(text[idx:])
+	}
+	bot := strings.ToLower(g_sBotUserName)
+	switch strings.ToLower(cmd){
+	case "/s", "/search":
+		return rest, true
+	}
+	if bot != ""{
+		switch strings.ToLower(cmd){
+		case "/s@" + bot, "/search@" + bot, "@" + bot:
+			return rest, true
+		case "/help@" + bot, "/start@" + bot:
+			return "/help", true
+		}
+	}
+	if g_sGroupPrefix != "" && strings.HasPrefix(text, g_sGroupPrefix){
+		return strings.TrimSpace(strings.TrimPrefix(text, g_sGroupPrefix)), true
+	}
+	return "", false
+}
+
+func resultOwnerKey(chatid int64, msgid int)string{
+	return fmt.Sprintf("zincsearch_bot_owner_%d_%d", chatid, msgid)
+}
+
+// 群组里的结果消息只有发起搜索的人能操作
+// 记录不存在时(已过期)不再限制，否则超过保存时间后发起人自己也翻不了页
+func isResultOwner(callback *model.CallbackQuery)bool{
+	if callback.Message == nil || callback.Message.Chat.Type == "private"{
+		return true
+	}
+	owner, err := db.Get(resultOwnerKey(callback.Message.Chat.ID, callback.Message.MessageID))
+	if err != nil || owner == ""{
+		return true
+	}
+	return owner == strconv.FormatInt(callback.From.ID, 10)
+}
+
+// 记录群组结果消息的发起人，并定时删除消息
+// 不删除消息时记录保存24小时，之后所有人都可以翻页
+// 定时删除在进程内，重启后未删除的消息需要手动清理
+func trackGroupResult(chatid int64, msgid int, userid int64){
+	ttl := 24 * time.Hour
+	if g_groupDeleteDelay > 0{
+		ttl = g_groupDeleteDelay
+	}
+	if err := db.SetWithExpire(resultOwnerKey(chatid, msgid), strconv.FormatInt(userid, 10), ttl); err != nil{
+		lib.XLogErr("save result owner", chatid, msgid, err)
+	}
+	if g_groupDeleteDelay <= 0{
+		return
+	}
+	time.AfterFunc(g_groupDeleteDelay, func(){
+		config := model.DeleteMessageConfig{ChatID: chatid, MessageID: msgid}
+		if err := tb.Call(&config); err != nil{
+			lib.XLogErr("delete result", chatid, msgid, err)
+		}
+	})
+}
+
+// inline模式: 在任意聊天输入 @bot 关键词，每条结果是一篇article，翻页靠next_offset
+func handleInlineQuery(updateid int, query *model.InlineQuery){
+	defer func() {
+		if err := recover(); err != nil {
+			lib.XLogErr("excption", err)
+		}
+	}()
+	config := model.AnswerInlineQueryConfig{
+		InlineQueryID: query.ID,
+		Results: []any{},
+		CacheTime: g_iInlineCacheTime,
+		IsPersonal: true,
 	}
-	//lib.XLogInfo(searchReq)
-	lib.XLogInfo(result)
-	for _, hit := range result.Hits.Hits {
-		doc := zincsearch.Document{ID:hit.ID}
-		if hit.Source["contact_type"] != nil {
-			doc.ContactType = hit.Source["contact_type"].(string)
-			if doc.ContactType == "yuni" || doc.ContactType == "siliao"{
-				values := strings.Split(hit.ID, "_")
-				if len(values) == 2{
-					doc.ID = values[0] + "/" + values[1]
+	keyword := strings.TrimSpace(query.Query)
+	if keyword == ""{
+		tb.Call(&config)
+		return
+	}
+	if !checkInlineRate(query.From.ID){
+		config.CacheTime = 0
+		tb.Call(&config)
+		return
+	}
+	from, err := strconv.Atoi(query.Offset)
+	if err != nil || from < 0{
+		from = 0
+	}
+	start := time.Now()
+	doc_list, total, err := cachedSearch(updateid, getCacheVersion(), keyword, from, g_iInlinePageCount)
+	if err != nil{
+		lib.XLogErr("searchIndex", updateid, keyword, err)
+		// 不缓存失败的结果，用户重新输入即可重试
+		config.CacheTime = 0
+		tb.Call(&config)
+		return
+	}
+	go logSearch(model.SearchLog{
+		UserHash: hashUser(query.From.ID),
+		Query: zincsearch.ParseQuery(keyword).String(),
+		Hits: total,
+		Page: from / g_iInlinePageCount,
+		LatencyMs: time.Since(start).Milliseconds(),
+		Source: "inline",
+	})
+	for _, doc := range doc_list{
+		url := fmt.Sprintf("https://t.me/%v", doc.ID)
+		title := doc.Title
+		if doc.ContactType == "yuni"{
+			title = "🎭️" + title
+		}
+		description := strings.TrimSpace(doc.Location + " " + doc.Tags)
+		description += " " + formatUserCount(doc.UserCount) + "人"
+		content := model.InputTextMessageContent{
+			Text: title + "\n" + url,
+			Entities: []model.MessageEntity{{
+				Type: "text_link",
+				URL: url,
+				Offset: 0,
+				Length: GetUTF16Len(title),
+			}},
+		}
+		open_text := "打开"
+		markup := model.InlineKeyboardMarkup{InlineKeyboard: [][]model.InlineKeyboardButton{
+			{{Text: open_text, URL: &url}},
+		}}
+		article := model.InlineQueryResultArticle{
+			Type: "article",
+			// 用户选中后chosen_inline_result带回这个id，记录点击
+			ID: inlineResultID(doc.ID),
+			Title: title,
+			InputMessageContent: content,
+			ReplyMarkup: &markup,
+			URL: url,
+			HideURL: true,
+			Description: description,
+		}
+		config.Results = append(config.Results, article)
+	}
+	if from + len(doc_list) < total && len(doc_list) > 0{
+		config.NextOffset = strconv.Itoa(from + len(doc_list))
+	}
+	if err := tb.Call(&config); err != nil{
+		lib.XLogErr("answerInlineQuery", updateid, keyword, err)
+	}
+}
+
+// inline结果id最长64字节
+func inlineResultID(docid string)string{
+	if len(docid) > 64{
+		return docid[:64]
+	}
+	return docid
+}
+
+// 需要在BotFather开启inline feedback才会收到
+func handleChosenInlineResult(result *model.ChosenInlineResult){
+	var userid int64
+	if result.From != nil{
+		userid = result.From.ID
+	}
+	logSearch(model.SearchLog{
+		UserHash: hashUser(userid),
+		Query: zincsearch.ParseQuery(result.Query).String(),
+		Clicked: result.ResultID,
+		Source: "click",
+	})
+}
+
+// 搜索结果消息对应的搜索语句，从同一条消息的订阅或者翻页按钮里取，都没有时为空
+func resultQuery(msg *model.Message)string{
+	if msg == nil || msg.ReplyMarkup == nil{
+		return ""
+	}
+	query := ""
+	for _, row := range msg.ReplyMarkup.InlineKeyboard{
+		for _, button := range row{
+			if button.CallbackData == nil{
+				continue
+			}
+			values := strings.Split(*button.CallbackData, "$$")
+			if len(values) == 2 && values[0] == "sub"{
+				return values[1]
+			}
+			if len(values) == 3 && query == ""{
+				if keyword, ok := pageQuery(values[0]); ok{
+					query = zincsearch.ParseQuery(keyword).String()
 				}
 			}
 		}
-		if hit.Source["title"] != nil{
-			doc.Title = hit.Source["title"].(string)
+	}
+	return query
+}
+
+func hashUser(userid int64)string{
+	sum := sha256.Sum256([]byte(g_sAnalyticsSalt + strconv.FormatInt(userid, 10)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// 搜索日志写入redis stream，同时累加按天的统计供kkoa_bot的search_stats查看
+// 统计只算第一页，翻页不重复计数
+func logSearch(entry model.SearchLog){
+	defer func() {
+		if err := recover(); err != nil {
+			lib.XLogErr("excption", err)
+		}
+	}()
+	now := time.Now()
+	entry.TS = now.Unix()
+	data, err := json.Marshal(entry)
+	if err != nil{
+		return
+	}
+	if err := db.XAdd("search_log", 100000, map[string]interface{}{"data": string(data)}); err != nil{
+		lib.XLogErr("log search", err)
+	}
+	day := now.Format("20060102")
+	expire := 30 * 24 * time.Hour
+	var keys []string
+	if entry.Source == "click"{
+		key := "search_stats_clicks_" + day
+		db.ZIncrBy(key, 1, entry.Clicked)
+		keys = append(keys, key)
+	}else if entry.Page == 0 && entry.Query != ""{
+		key := "search_stats_queries_" + day
+		db.ZIncrBy(key, 1, entry.Query)
+		keys = append(keys, key)
+		if entry.Hits == 0{
+			key = "search_stats_zero_" + day
+			db.ZIncrBy(key, 1, entry.Query)
+			keys = append(keys, key)
+		}
+	}
+	key := "search_stats_users_" + day
+	db.PFAdd(key, entry.UserHash)
+	keys = append(keys, key)
+	for _, key := range keys{
+		db.Expire(key, expire)
+	}
+}
+
+func formatUserCount(count int)string{
+	if count > 1000{
+		return strconv.Itoa(count / 1000) + "k"
+	}
+	return strconv.Itoa(count)
+}
+
+func subscribeLock(userid int64)*sync.Mutex{
+	lock, _ := g_subscribeLocks.LoadOrStore(userid, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+func subscriptionKey(userid int64)string{
+	return "zincsearch_bot_subs_" + strconv.FormatInt(userid, 10)
+}
+
+func getSubscriptions(userid int64)model.SavedSearchList{
+	var list model.SavedSearchList
+	db.GetStruct(subscriptionKey(userid), &list)
+	return list
+}
+
+func saveSubscriptions(userid int64, list model.SavedSearchList)error{
+	uid := strconv.FormatInt(userid, 10)
+	if len(list.Searches) == 0{
+		db.DelFromSet("zincsearch_bot_sub_users", uid)
+		return db.Del(subscriptionKey(userid))
+	}
+	if err := db.SetStruct(subscriptionKey(userid), list); err != nil{
+		return err
+	}
+	return db.AddToSet("zincsearch_bot_sub_users", uid)
+}
+
+// 订阅按钮，callback_data放不下时不显示
+func subscribeButton(query string)[]model.InlineKeyboardButton{
+	data := "sub$$" + zincsearch.ParseQuery(query).String()
+	if len(data) > 64 || data == "sub$$"{
+		return nil
+	}
+	return []model.InlineKeyboardButton{{Text: "🔔 订阅此搜索，有新结果时通知我", CallbackData: &data}}
+}
+
+func answerCallback(callback *model.CallbackQuery, text string){
+	config := model.AnswerCallbackQueryConfig{CallbackID: callback.ID, Text: text}
+	tb.Call(&config)
+}
+
+func subscribe(callback *model.CallbackQuery, query string){
+	userid := callback.From.ID
+	lock := subscribeLock(userid)
+	lock.Lock()
+	defer lock.Unlock()
+	list := getSubscriptions(userid)
+	for _, v := range list.Searches{
+		if v.Query == query{
+			answerCallback(callback, "已经订阅过了")
+			return
+		}
+	}
+	if len(list.Searches) >= g_iSubscribeLimit{
+		answerCallback(callback, fmt.Sprintf("最多订阅%d个搜索，发送 /subs 管理订阅", g_iSubscribeLimit))
+		return
+	}
+	now := time.Now().Unix()
+	list.Searches = append(list.Searches, model.SavedSearch{Query: query, CreatedAt: now, LastNotified: now})
+	if err := saveSubscriptions(userid, list); err != nil{
+		lib.XLogErr("subscribe", userid, query, err)
+		answerCallback(callback, "订阅失败，请稍后重试")
+		return
+	}
+	answerCallback(callback, "订阅成功，有新结果时会通知你，发送 /subs 管理订阅")
+}
+
+func unsubscribe(callback *model.CallbackQuery, query string){
+	userid := callback.From.ID
+	lock := subscribeLock(userid)
+	lock.Lock()
+	defer lock.Unlock()
+	list := getSubscriptions(userid)
+	var left model.SavedSearchList
+	for _, v := range list.Searches{
+		if v.Query != query{
+			left.Searches = append(left.Searches, v)
+		}
+	}
+	if err := saveSubscriptions(userid, left); err != nil{
+		lib.XLogErr("unsubscribe", userid, query, err)
+		answerCallback(callback, "操作失败，请稍后重试")
+		return
+	}
+	answerCallback(callback, "已取消订阅: " + query)
+}
+
+func unsubscribeButton(query string)[]model.InlineKeyboardButton{
+	data := "unsub$$" + query
+	if len(data) > 64{
+		return nil
+	}
+	return []model.InlineKeyboardButton{{Text: "🔕 取消订阅 " + query, CallbackData: &data}}
+}
+
+func listSubscriptions(msg *model.Message){
+	if msg.From == nil{
+		return
+	}
+	list := getSubscriptions(msg.From.ID)
+	if len(list.Searches) == 0{
+		replyText(msg.Chat.ID, msg.MessageID, "暂无订阅，在搜索结果下点击订阅按钮即可订阅")
+		return
+	}
+	config := model.SendMessageConfig{ChatID: msg.Chat.ID}
+	config.ReplyParams.MessageID = msg.MessageID
+	config.Text = fmt.Sprintf("你订阅了%d个搜索，点击按钮取消订阅", len(list.Searches))
+	var markup model.InlineKeyboardMarkup
+	for _, v := range list.Searches{
+		if row := unsubscribeButton(v.Query); row != nil{
+			markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+		}
+	}
+	config.ReplyMarkup = markup
+	tb.Call(&config)
+}
+
+// kkoa_bot每次写入文档都会更新zincsearch_bot_last_ingest
+// 写入停止g_iSubscribeQuiet秒后认为一批写入结束，重新执行所有订阅
+func runSubscriptions(){
+	last_run := int64(0)
+	for range time.Tick(time.Minute){
+		value, err := db.Get("zincsearch_bot_last_ingest")
+		if err != nil{
+			continue
+		}
+		ingest, _ := strconv.ParseInt(value, 10, 64)
+		now := time.Now().Unix()
+		if ingest <= last_run || now - ingest < g_iSubscribeQuiet{
+			continue
+		}
+		last_run = now
+		users, err := db.GetSetMembers("zincsearch_bot_sub_users")
+		if err != nil{
+			lib.XLogErr("subscription users", err)
+			continue
+		}
+		lib.XLogInfo("run subscriptions", len(users))
+		for _, uid := range users{
+			if userid, err := strconv.ParseInt(uid, 10, 64); err == nil{
+				notifySubscriber(userid)
+			}
+		}
+	}
+}
+
+// 查询和发送不持有锁，推送完再按最新的订阅列表更新推送位置
+func notifySubscriber(userid int64){
+	defer func() {
+		if err := recover(); err != nil {
+			lib.XLogErr("excption", err)
+		}
+	}()
+	lock := subscribeLock(userid)
+	lock.Lock()
+	list := getSubscriptions(userid)
+	lock.Unlock()
+	notified := make(map[string]int64)
+	for _, sub := range list.Searches{
+		last, err := collectSubscription(sub, func(docs []zincsearch.Document, remaining int){
+			sendSubscriptionResults(userid, sub.Query, docs, remaining)
+			// 避免触发telegram的发送频率限制
+			time.Sleep(100 * time.Millisecond)
+		})
+		if err != nil{
+			lib.XLogErr("subscription search", userid, sub.Query, err)
+		}
+		if last > sub.LastNotified{
+			notified[sub.Query] = last
+		}
+	}
+	if len(notified) == 0{
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	// 推送期间用户可能取消或者新增了订阅，重新读取后只更新推送过的
+	list = getSubscriptions(userid)
+	changed := false
+	for i, sub := range list.Searches{
+		if last, ok := notified[sub.Query]; ok && last > sub.LastNotified{
+			list.Searches[i].LastNotified = last
+			changed = true
+		}
+	}
+	if changed{
+		if err := saveSubscriptions(userid, list); err != nil{
+			lib.XLogErr("save subscriptions", userid, err)
+		}
+	}
+}
+
+// 按写入时间从早到晚分页交给send，最多g_iSubscribeMaxPages页，返回推送到的写入时间
+// 没推送完时remaining是剩下的数量，下次从推送到的位置继续
+func collectSubscription(sub model.SavedSearch, send func(docs []zincsearch.Document, remaining int))(int64, error){
+	last := sub.LastNotified
+	for page := 0; page < g_iSubscribeMaxPages; page++{
+		from := page * g_iSubscribePageSize
+		// 多取一条判断后面还有没有
+		docs, total, err := newDocuments(sub, from, g_iSubscribePageSize + 1)
+		if err != nil{
+			return last, err
+		}
+		if len(docs) == 0{
+			return last, nil
+		}
+		more := len(docs) > g_iSubscribePageSize
+		var next zincsearch.Document
+		if more{
+			next = docs[g_iSubscribePageSize]
+			docs = docs[:g_iSubscribePageSize]
+		}
+		last = docs[len(docs) - 1].CreatedAt
+		if !more{
+			send(docs, 0)
+			return last, nil
+		}
+		if page == g_iSubscribeMaxPages - 1{
+			send(docs, total - from - len(docs))
+			// 下一个文档和最后推送的同一秒写入时退一秒，宁可重复推送也不漏
+			if next.CreatedAt == last{
+				last--
+			}
+			return last, nil
+		}
+		send(docs, 0)
+	}
+	return last, nil
+}
+
+// 订阅的搜索在上次推送之后新写入的文档，按写入时间从早到晚，返回一页和总数
+func newDocuments(sub model.SavedSearch, from int, size int)([]zincsearch.Document, int, error){
+	parsed := zincsearch.ParseQuery(sub.Query)
+	query := &zincsearch.BoolQuery{
+		Must: []zincsearch.Query{visibleQuery(parsed.QueryWithSynonyms(getSynonyms()))},
+		Filter: []zincsearch.Query{zincsearch.Range("created_at", map[string]interface{}{"gt": sub.LastNotified})},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), zincSearchTimeout)
+	defer cancel()
+	result, err := zincSearcher.Query(ctx, getSearchIndex(), &zincsearch.QueryRequest{
+		Query: query.Query(),
+		From: from,
+		Size: size,
+		Sort: []string{"created_at", "_id"},
+	})
+	if err != nil{
+		return nil, 0, err
+	}
+	var docs []zincsearch.Document
+	for _, hit := range result.Hits.Hits{
+		docs = append(docs, docFromHit(hit))
+	}
+	return docs, result.Hits.Total.Value, nil
+}
+
+func sendSubscriptionResults(userid int64, query string, docs []zincsearch.Document, remaining int){
+	config := model.SendMessageConfig{ChatID: userid}
+	config.LinkPreviewOption.IsDisable = true
+	text := fmt.Sprintf("🔔 你订阅的「%s」有%d个新结果:\n", query, len(docs))
+	for i, doc := range docs{
+		title := fmt.Sprintf("%d. %s - %s人", i + 1, doc.Title, formatUserCount(doc.UserCount))
+		config.Entities = append(config.Entities, model.MessageEntity{
+			Type: "text_link",
+			URL: fmt.Sprintf("https://t.me/%v", doc.ID),
+			Offset: GetUTF16Len(text),
+			Length: GetUTF16Len(title),
+		})
+		text += title + "\n"
+	}
+	if remaining > 0{
+		text += fmt.Sprintf("还有%d个新结果，下次有新文档时继续推送，也可以直接搜索查看", remaining)
+	}
+	config.Text = text
+	if row := unsubscribeButton(query); row != nil{
+		config.ReplyMarkup = model.InlineKeyboardMarkup{InlineKeyboard: [][]model.InlineKeyboardButton{row}}
+	}
+	if err := tb.Call(&config); err != nil{
+		lib.XLogErr("send subscription", userid, query, err)
+	}
+}
+
+// 频率检查，返回是否允许以及需要等待的时间
+// query为空时只检查用户的频率，翻页不检查同一个搜索语句的次数；redis出错时放行
+func checkRate(userid int64, query string)(bool, time.Duration){
+	if !g_bFreqCheck{
+		return true, 0
+	}
+	uid := strconv.FormatInt(userid, 10)
+	cooldown_key := "zincsearch_bot_rate_cooldown_" + uid
+	if ttl, err := db.TTL(cooldown_key); err == nil && ttl > 0{
+		return false, ttl
+	}
+	count, err := db.SlidingWindowAdd("zincsearch_bot_rate_user_" + uid, g_rateUserWindow)
+	exceeded := err == nil && count > g_iRateUserLimit
+	if !exceeded && query != ""{
+		normalized := zincsearch.ParseQuery(query).String()
+		sum := sha1.Sum([]byte(normalized))
+		key := "zincsearch_bot_rate_query_" + uid + "_" + hex.EncodeToString(sum[:6])
+		count, err = db.SlidingWindowAdd(key, g_rateQueryWindow)
+		exceeded = err == nil && count > g_iRateQueryLimit
+	}
+	if !exceeded{
+		return true, 0
+	}
+	// 1小时内每次超限冷却时间翻倍
+	level_key := "zincsearch_bot_rate_level_" + uid
+	level, err := db.Incr(level_key)
+	if err != nil{
+		level = 1
+	}
+	db.Expire(level_key, time.Hour)
+	cooldown := g_rateCooldown
+	for i := int64(1); i < level && cooldown < g_rateCooldownMax; i++{
+		cooldown *= 2
+	}
+	if cooldown > g_rateCooldownMax{
+		cooldown = g_rateCooldownMax
+	}
+	db.SetWithExpire(cooldown_key, "1", cooldown)
+	lib.XLogErr("rate limited", uid, level, cooldown)
+	return false, cooldown
+}
+
+// inline查询用单独的计数，每输入一个字都会查询，不占用消息搜索的次数，超限时返回空结果也不触发冷却
+func checkInlineRate(userid int64)bool{
+	if !g_bFreqCheck{
+		return true
+	}
+	count, err := db.SlidingWindowAdd("zincsearch_bot_rate_inline_" + strconv.FormatInt(userid, 10), g_rateInlineWindow)
+	return err != nil || count <= g_iRateInlineLimit
+}
+
+func rateLimitText(wait time.Duration)string{
+	seconds := int(wait.Seconds())
+	if seconds < 1{
+		seconds = 1
+	}
+	return fmt.Sprintf("搜索太频繁啦，请%d秒后再试🙏", seconds)
+}
+
+// 索引里的文档id，与你和私聊的id在docFromHit里把_换成了/
+func docIndexID(doc zincsearch.Document)string{
+	if doc.ContactType == "yuni" || doc.ContactType == "siliao"{
+		return strings.Replace(doc.ID, "/", "_", 1)
+	}
+	return doc.ID
+}
+
+// 每条结果一个详情按钮，按序号排成两行
+func detailButtons(docs []zincsearch.Document, from int)[][]model.InlineKeyboardButton{
+	var rows [][]model.InlineKeyboardButton
+	var row []model.InlineKeyboardButton
+	for i, doc := range docs{
+		data := "d$$" + docIndexID(doc)
+		if len(data) > 64{
+			continue
+		}
+		row = append(row, model.InlineKeyboardButton{Text: "ℹ️" + strconv.Itoa(from + i + 1), CallbackData: &data})
+		if len(row) == 5{
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0{
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+var contactTypeNames = map[string]string{
+	"telegram": "Telegram频道",
+	"yuni": "与你",
+	"siliao": "飞机私聊",
+	"wechat": "微信",
+	"qq": "QQ",
+}
+
+// 点评里的评分，取第一段数字，如 "8.5分" => 8.5
+func parseMark(mark string)(float64, bool){
+	start := strings.IndexFunc(mark, unicode.IsDigit)
+	if start == -1{
+		return 0, false
+	}
+	end := start
+	for end < len(mark) && (mark[end] == '.' || (mark[end] >= '0' && mark[end] <= '9')){
+		end++
+	}
+	value, err := strconv.ParseFloat(strings.TrimSuffix(mark[start:end], "."), 64)
+	return value, err == nil
+}
+
+// dog_bot导入的点评报告，按js_name索引
+func getJsReports(js_name string)[]model.JsReport{
+	var index model.JsReportIndex
+	key := base64.StdEncoding.EncodeToString([]byte(strings.TrimPrefix(js_name, "#")))
+	if err := db.GetStruct("jsreport_index_" + key, &index); err != nil{
+		return nil
+	}
+	var reports []model.JsReport
+	for i, v := range index.Keys{
+		if i >= 50{
+			break
+		}
+		var report model.JsReport
+		if err := db.GetStruct(v, &report); err == nil{
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
+
+func getDocument(id string)(zincsearch.Document, bool){
+	ctx, cancel := context.WithTimeout(context.Background(), zincSearchTimeout)
+	defer cancel()
+	result, err := zincSearcher.Query(ctx, getSearchIndex(), &zincsearch.QueryRequest{
+		Query: zincsearch.Term("_id", id),
+		Size: 1,
+	})
+	if err != nil || len(result.Hits.Hits) == 0{
+		lib.XLogErr("get document", id, err)
+		return zincsearch.Document{}, false
+	}
+	return docFromHit(result.Hits.Hits[0]), true
+}
+
+// 结果详情，回复在结果消息下
+func showDetail(callback *model.CallbackQuery, id string){
+	if callback.Message == nil{
+		return
+	}
+	doc, ok := getDocument(id)
+	if !ok{
+		answerCallback(callback, "没有找到这条结果，可能已经下架")
+		return
+	}
+	// 打开详情和inline选中结果一样算一次点击
+	go logSearch(model.SearchLog{
+		UserHash: hashUser(callback.From.ID),
+		Query: resultQuery(callback.Message),
+		Clicked: doc.ID,
+		Source: "click",
+	})
+	answerCallback(callback, "")
+	text := ""
+	var entities []model.MessageEntity
+	link := func(title, url string){
+		entities = append(entities, model.MessageEntity{
+			Type: "text_link",
+			URL: url,
+			Offset: GetUTF16Len(text),
+			Length: GetUTF16Len(title),
+		})
+		text += title + "\n"
+	}
+	link("📄 " + doc.Title, fmt.Sprintf("https://t.me/%v", doc.ID))
+	if doc.Description != ""{
+		text += "简介: " + doc.Description + "\n"
+	}
+	if doc.Location != ""{
+		text += "地区: " + doc.Location + "\n"
+	}
+	if doc.Tags != ""{
+		text += "标签: " + doc.Tags + "\n"
+	}
+	contact := contactTypeNames[doc.ContactType]
+	if contact == ""{
+		contact = doc.ContactType
+	}
+	text += "联系方式: " + contact + "\n"
+	text += "人数: " + strconv.Itoa(doc.UserCount)
+	if doc.RefreshedAt > 0{
+		text += "（更新于 " + time.Unix(doc.RefreshedAt, 0).Format("2006-01-02") + "）"
+	}
+	text += "\n"
+	if reports := getJsReports(doc.JsName); len(reports) > 0{
+		total, count := 0.0, 0
+		for _, report := range reports{
+			if mark, ok := parseMark(report.Mark); ok{
+				total += mark
+				count++
+			}
+		}
+		text += fmt.Sprintf("\n📝 点评: %d条", len(reports))
+		if count > 0{
+			text += fmt.Sprintf("，平均评分 %.1f", total / float64(count))
 		}
-		if hit.Source["description"] != nil{
-			doc.Description = hit.Source["description"].(string)
+		text += "\n"
+		for i, report := range reports{
+			if i >= 5{
+				break
+			}
+			link(report.Ly + "_" + report.Time + "_的验证报告", "https://t.me/" + report.GroupUserName + "/" + strconv.Itoa(report.MessageID))
 		}
-		if hit.Source["chat_id"] != nil{
-			doc.ChatID = hit.Source["chat_id"].(string)
+	}
+
+	msg := callback.Message
+	config := model.SendMessageConfig{ChatID: msg.Chat.ID, Text: text, Entities: entities}
+	config.LinkPreviewOption.IsDisable = true
+	config.ReplyParams.MessageID = msg.MessageID
+	if msg.IsTopicMessage{
+		config.ThreadID = msg.MessageThreadID
+	}
+	if err := tb.Call(&config); err != nil{
+		lib.XLogErr("send detail", id, err)
+		return
+	}
+	if msg.Chat.Type != "private"{
+		trackGroupResult(msg.Chat.ID, config.Response.MessageID, callback.From.ID)
+	}
+}
+
+// 搜索ZincSearch
+// 结果下面的反馈按钮，购买推广打开和bot的私聊自助购买
+func feedbackButtons()[]model.InlineKeyboardButton{
+	land_url := "tg://resolve?domain=kkhelper_bot"
+	if g_sBotUserName == ""{
+		return []model.InlineKeyboardButton{{Text:"👣反馈搜索问题|添加频道|购买推广👣", URL: &land_url}}
+	}
+	buy_url := "https://t.me/" + g_sBotUserName + "?start=buy"
+	return []model.InlineKeyboardButton{{Text:"👣反馈问题|添加频道👣", URL: &land_url}, {Text:"📢购买推广", URL: &buy_url}}
+}
+
+// 自助购买推广: /buy 选择推广位置，关键词推广输入关键词，输入频道，选择天数，预览后用telegram stars付款
+// 付款成功后写入广告列表，和kkoa_bot的add_adfeed/add_topfeed添加的一样
+func adOrderKey(id string)string{
+	return "zincsearch_bot_adorder_" + id
+}
+
+// 用户正在填写的订单id
+func adOrderUserKey(userid int64)string{
+	return "zincsearch_bot_adorder_user_" + strconv.FormatInt(userid, 10)
+}
+
+func getAdOrder(id string)(model.AdOrder, bool){
+	var order model.AdOrder
+	if err := db.GetStruct(adOrderKey(id), &order); err != nil || order.ID != id{
+		return order, false
+	}
+	return order, true
+}
+
+// 未付款的订单一天后过期，付款后一直保存
+func saveAdOrder(order model.AdOrder)error{
+	if order.Step == "paid"{
+		return db.SetStruct(adOrderKey(order.ID), order)
+	}
+	if err := db.SetStructWithExpire(adOrderKey(order.ID), order, 24 * time.Hour); err != nil{
+		return err
+	}
+	return db.SetWithExpire(adOrderUserKey(order.UserID), order.ID, 30 * time.Minute)
+}
+
+func cancelAdOrder(order model.AdOrder){
+	db.Del(adOrderKey(order.ID))
+	db.Del(adOrderUserKey(order.UserID))
+}
+
+// 等待用户输入关键词或频道的订单
+func pendingAdOrder(userid int64)(model.AdOrder, bool){
+	id, err := db.Get(adOrderUserKey(userid))
+	if err != nil || id == ""{
+		return model.AdOrder{}, false
+	}
+	order, ok := getAdOrder(id)
+	if !ok || order.UserID != userid || (order.Step != "keyword" && order.Step != "channel"){
+		return order, false
+	}
+	return order, true
+}
+
+func adPrice(kind string)int{
+	if kind == "topfeed"{
+		return g_iTopFeedPrice
+	}
+	return g_iAdFeedPrice
+}
+
+func adOrderButton(order model.AdOrder, text string, action string)model.InlineKeyboardButton{
+	data := "buy$$" + order.ID + ":" + action
+	return model.InlineKeyboardButton{Text: text, CallbackData: &data}
+}
+
+func sendAdOrderMessage(order model.AdOrder, text string, rows [][]model.InlineKeyboardButton){
+	config := model.SendMessageConfig{ChatID: order.UserID, Text: text}
+	if rows != nil{
+		config.ReplyMarkup = model.InlineKeyboardMarkup{InlineKeyboard: rows}
+	}
+	tb.Call(&config)
+}
+
+func editAdOrderMessage(callback *model.CallbackQuery, text string, entities []model.MessageEntity, rows [][]model.InlineKeyboardButton){
+	config := model.EditMessageTextConfig{ChatID: callback.Message.Chat.ID, MessageID: callback.Message.MessageID, Text: text, Entities: entities}
+	config.LinkPreviewOption.IsDisable = true
+	config.ReplyMarkup.InlineKeyboard = rows
+	if config.ReplyMarkup.InlineKeyboard == nil{
+		config.ReplyMarkup.InlineKeyboard = [][]model.InlineKeyboardButton{}
+	}
+	tb.Call(&config)
+}
+
+const adChannelPrompt = "发送要推广的频道用户名，如 @channel 或 https://t.me/channel，频道需要是公开的\n发送 /cancel 取消"
+
+func startAdOrder(msg *model.Message, userid int64){
+	order := model.AdOrder{
+		ID: strconv.FormatInt(time.Now().UnixNano(), 36),
+		UserID: userid,
+		Step: "slot",
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := saveAdOrder(order); err != nil{
+		lib.XLogErr("save adorder", order, err)
+		replyText(msg.Chat.ID, msg.MessageID, "系统繁忙，请稍后重试")
+		return
+	}
+	text := fmt.Sprintf("选择推广位置:\n🔥 全局推广: 所有搜索结果顶部展示，%d⭐/天\n🔝 关键词推广: 搜索指定关键词时展示，%d⭐/天", g_iAdFeedPrice, g_iTopFeedPrice)
+	sendAdOrderMessage(order, text, [][]model.InlineKeyboardButton{
+		{adOrderButton(order, "🔥 全局推广", "slot:adfeed")},
+		{adOrderButton(order, "🔝 关键词推广", "slot:topfeed")},
+		{adOrderButton(order, "取消", "cancel")},
+	})
+}
+
+// 频道链接或用户名里取出用户名
+func parseChannelName(text string)(string, bool){
+	name := strings.TrimSpace(text)
+	for _, prefix := range []string{"https://", "http://", "t.me/", "@"}{
+		name = strings.TrimPrefix(name, prefix)
+	}
+	if idx := strings.IndexAny(name, "/?"); idx != -1{
+		name = name[:idx]
+	}
+	if len(name) < 4 || len(name) > 32{
+		return "", false
+	}
+	for _, r := range name{
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_'){
+			return "", false
 		}
-		if hit.Source["user_count"] != nil{
-			tmp := hit.Source["user_count"].(float64)
-			doc.UserCount = int(tmp)
+	}
+	return name, true
+}
+
+func handleAdOrderInput(msg *model.Message, order model.AdOrder, text string){
+	if text == "/cancel"{
+		cancelAdOrder(order)
+		replyText(msg.Chat.ID, msg.MessageID, "已取消购买")
+		return
+	}
+	if order.Step == "keyword"{
+		keyword := strings.ToLower(strings.TrimSpace(text))
+		if strings.ContainsAny(keyword, " \t\n$:") || strings.HasPrefix(keyword, "/") || len([]rune(keyword)) > 20{
+			replyText(msg.Chat.ID, msg.MessageID, "关键词只能是一个词，不超过20个字，请重新发送\n发送 /cancel 取消")
+			return
 		}
-		if hit.Source["js_name"] != nil{
-			doc.JsName = hit.Source["js_name"].(string)
+		order.Keyword = keyword
+		order.Step = "channel"
+		if err := saveAdOrder(order); err != nil{
+			lib.XLogErr("save adorder", order, err)
+			return
 		}
-		if hit.Source["js_type"] != nil{
-			doc.JsType = hit.Source["js_type"].(string)
+		replyText(msg.Chat.ID, msg.MessageID, adChannelPrompt)
+		return
+	}
+	name, ok := parseChannelName(text)
+	if !ok{
+		replyText(msg.Chat.ID, msg.MessageID, "频道用户名格式错误，请重新发送\n" + adChannelPrompt)
+		return
+	}
+	config := model.GetChatConfig{ChatID: "@" + name}
+	if err := tb.Call(&config); err != nil || (config.Response.Type != "channel" && config.Response.Type != "supergroup"){
+		lib.XLogErr("adorder getchat", name, err)
+		replyText(msg.Chat.ID, msg.MessageID, "找不到这个频道，请确认频道是公开的后重新发送\n发送 /cancel 取消")
+		return
+	}
+	order.ChatID = name
+	if config.Response.UserName != ""{
+		order.ChatID = config.Response.UserName
+	}
+	order.Title = config.Response.Title
+	order.Step = "days"
+	if err := saveAdOrder(order); err != nil{
+		lib.XLogErr("save adorder", order, err)
+		return
+	}
+	var rows [][]model.InlineKeyboardButton
+	for _, days := range g_adDays{
+		text := fmt.Sprintf("%d天 · %d⭐", days, days * adPrice(order.Kind))
+		rows = append(rows, []model.InlineKeyboardButton{adOrderButton(order, text, "days:" + strconv.Itoa(days))})
+	}
+	rows = append(rows, []model.InlineKeyboardButton{adOrderButton(order, "取消", "cancel")})
+	sendAdOrderMessage(order, "推广频道: " + order.Title + "\n选择推广天数:", rows)
+}
+
+// 广告在搜索结果里的样子
+func adOrderPreview(order model.AdOrder)(string, []model.MessageEntity){
+	text := "预览，搜索结果里的展示效果:\n\n"
+	title := "🔥 " + order.Title
+	if order.Kind == "topfeed"{
+		title = "🔝 " + order.Title
+	}
+	entities := []model.MessageEntity{{
+		Type: "text_link",
+		URL: "https://t.me/" + order.ChatID,
+		Offset: GetUTF16Len(text),
+		Length: GetUTF16Len(title),
+	}}
+	text += title + "\n\n"
+	if order.Kind == "topfeed"{
+		text += "关键词: " + order.Keyword + "\n"
+	}
+	text += fmt.Sprintf("推广天数: %d天\n价格: %d⭐", order.Days, order.Amount)
+	return text, entities
+}
+
+// 回调数据是 buy$$订单id:操作[:参数]
+func handleAdOrderCallback(callback *model.CallbackQuery, data string){
+	values := strings.SplitN(data, ":", 3)
+	order, ok := getAdOrder(values[0])
+	if !ok || order.UserID != callback.From.ID || len(values) < 2{
+		answerCallback(callback, "订单已过期，请重新发送 /buy")
+		return
+	}
+	if order.Step == "paid"{
+		answerCallback(callback, "订单已付款")
+		return
+	}
+	arg := ""
+	if len(values) == 3{
+		arg = values[2]
+	}
+	switch values[1]{
+	case "cancel":
+		cancelAdOrder(order)
+		editAdOrderMessage(callback, "已取消购买", nil, nil)
+	case "slot":
+		if order.Step != "slot" || (arg != "adfeed" && arg != "topfeed"){
+			break
+		}
+		order.Kind = arg
+		order.Step = "channel"
+		text := adChannelPrompt
+		if arg == "topfeed"{
+			order.Step = "keyword"
+			text = "发送要购买的搜索关键词，如 天河\n发送 /cancel 取消"
+		}
+		if err := saveAdOrder(order); err != nil{
+			lib.XLogErr("save adorder", order, err)
+			break
+		}
+		editAdOrderMessage(callback, text, nil, nil)
+	case "days":
+		days, err := strconv.Atoi(arg)
+		if err != nil || (order.Step != "days" && order.Step != "confirm"){
+			break
+		}
+		valid := false
+		for _, v := range g_adDays{
+			valid = valid || v == days
+		}
+		if !valid{
+			break
+		}
+		order.Days = days
+		order.Amount = days * adPrice(order.Kind)
+		order.Step = "confirm"
+		if err := saveAdOrder(order); err != nil{
+			lib.XLogErr("save adorder", order, err)
+			break
+		}
+		text, entities := adOrderPreview(order)
+		editAdOrderMessage(callback, text, entities, [][]model.InlineKeyboardButton{
+			{adOrderButton(order, fmt.Sprintf("💳 支付 %d⭐", order.Amount), "pay")},
+			{adOrderButton(order, "取消", "cancel")},
+		})
+	case "pay":
+		if order.Step != "confirm" && order.Step != "invoiced"{
+			break
+		}
+		if g_bFakePayment && g_mapAdmins[callback.From.UserName]{
+			completeAdOrder(order, "fake_" + order.ID)
+			break
 		}
-		if hit.Source["location"] != nil{
-			doc.Location = hit.Source["location"].(string)
+		invoice := model.SendInvoiceConfig{
+			ChatID: order.UserID,
+			Title: fmt.Sprintf("搜索推广%d天", order.Days),
+			Description: fmt.Sprintf("推广频道 @%s，%d天", order.ChatID, order.Days),
+			Payload: "adorder:" + order.ID,
+			Currency: "XTR",
+			Prices: []model.LabeledPrice{{Label: fmt.Sprintf("推广%d天", order.Days), Amount: order.Amount}},
 		}
-		if hit.Source["tags"] != nil{
-			doc.Tags = hit.Source["tags"].(string)
+		if err := tb.Call(&invoice); err != nil{
+			lib.XLogErr("sendinvoice", order, err)
+			answerCallback(callback, "创建付款单失败，请稍后重试")
+			return
 		}
-		result_list = append(result_list, doc)
+		order.Step = "invoiced"
+		if err := saveAdOrder(order); err != nil{
+			lib.XLogErr("save adorder", order, err)
+		}
+	}
+	answerCallback(callback, "")
+}
+
+func handlePreCheckoutQuery(query *model.PreCheckoutQuery){
+	config := model.AnswerPreCheckoutQueryConfig{PreCheckoutQueryID: query.ID, OK: true}
+	order, ok := getAdOrder(strings.TrimPrefix(query.InvoicePayload, "adorder:"))
+	if !ok || !validPreCheckout(query, order){
+		lib.XLogErr("reject pre checkout", *query, order)
+		config.OK = false
+		config.ErrorMessage = "订单已失效，请重新发送 /buy 购买"
+	}
+	if err := tb.Call(&config); err != nil{
+		lib.XLogErr("answer pre checkout", query.ID, err)
 	}
-	return result_list, result.Hits.Total.Value, nil
+}
+
+// 付款前检查订单，不是下单的用户、订单已付款或者没发过付款单、金额不对时拒绝
+func validPreCheckout(query *model.PreCheckoutQuery, order model.AdOrder)bool{
+	return query.From != nil && order.UserID == query.From.ID && order.Step == "invoiced" && query.Currency == "XTR" && query.TotalAmount == order.Amount
+}
+
+func handleSuccessfulPayment(msg *model.Message){
+	payment := msg.SuccessfulPayment
+	order, ok := getAdOrder(strings.TrimPrefix(payment.InvoicePayload, "adorder:"))
+	if !ok{
+		lib.XLogErr("unknown adorder payment", *payment)
+		notifyAdAdmin(fmt.Sprintf("收到未知订单的付款 %s，付款编号 %s，用户 %d", payment.InvoicePayload, payment.TelegramPaymentChargeID, msg.Chat.ID))
+		replyText(msg.Chat.ID, msg.MessageID, "付款成功，但订单已失效，请联系 @kkhelper_bot 处理，付款编号 " + payment.TelegramPaymentChargeID)
+		return
+	}
+	completeAdOrder(order, payment.TelegramPaymentChargeID)
+}
+
+// 付款后写入广告列表，同一个频道还在推广期内的顺延天数
+func completeAdOrder(order model.AdOrder, chargeid string){
+	g_adorder_mutex.Lock()
+	defer g_adorder_mutex.Unlock()
+	if current, ok := getAdOrder(order.ID); ok && current.Step == "paid"{
+		return
+	}
+	key := "zincsearch_bot_adfeeds"
+	if order.Kind == "topfeed"{
+		key = "zincsearch_bot_topfeeds_" + base64.StdEncoding.EncodeToString([]byte(order.Keyword))
+	}
+	now := time.Now().Unix()
+	order.Step = "paid"
+	order.ChargeID = chargeid
+	order.PaidAt = now
+	if err := saveAdOrder(order); err != nil{
+		lib.XLogErr("save paid adorder", order, err)
+	}
+	db.Del(adOrderUserKey(order.UserID))
+	summary := fmt.Sprintf("%s @%s %d天 %d⭐ 付款编号 %s", order.Kind, order.ChatID, order.Days, order.Amount, chargeid)
+	if order.Kind == "topfeed"{
+		summary = order.Keyword + " " + summary
+	}
+	// kkoa_bot的add_adfeed也会改这个列表，在redis事务里读改写
+	var list model.AdFeedList
+	var end int64
+	err := db.UpdateStruct(key, &list, func()error{
+		end = applyAdOrder(&list, order, now)
+		return nil
+	})
+	if err != nil{
+		lib.XLogErr("save adfeeds", key, order, err)
+		notifyAdAdmin("推广已付款但写入失败，请手动添加: " + summary)
+		sendText(order.UserID, "付款成功，推广稍后由客服手动开通，付款编号 " + chargeid)
+		return
+	}
+	if _, err := db.Incr("zincsearch_bot_cache_version"); err != nil{
+		lib.XLogErr("invalidate search cache", err)
+	}
+	lib.XLogInfo("adorder paid", order)
+	notifyAdAdmin("新的推广订单: " + summary)
+	sendText(order.UserID, "付款成功，推广已生效，到期时间 " + time.Unix(end, 0).Format("2006-01-02 15:04"))
+}
+
+// 把订单写入广告列表，返回到期时间
+func applyAdOrder(list *model.AdFeedList, order model.AdOrder, now int64)int64{
+	duration := int64(order.Days) * 24 * 3600
+	for i, feed := range list.Feeds{
+		if feed.ChatID == order.ChatID && feed.TS > now{
+			list.Feeds[i].TS += duration
+			return list.Feeds[i].TS
+		}
+	}
+	list.Feeds = append(list.Feeds, model.AdFeed{
+		Title: order.Title,
+		ChatID: order.ChatID,
+		Order: g_iAdOrderWeight,
+		Start: now,
+		TS: now + duration,
+		Keyword: order.Keyword,
+	})
+	return now + duration
+}
+
+func notifyAdAdmin(text string){
+	if g_iAdNotifyChat != 0{
+		sendText(g_iAdNotifyChat, text)
+	}
+}
+
+func searchIndex(updateid int, query string, page int, pageSize int) ([]zincsearch.Document, int, error) {
+	var result_list []zincsearch.Document
+	hits, total, err := rankedSearch(updateid, query, page, pageSize)
+	if err != nil {
+		return result_list, 0, err
+	}
+	for _, hit := range hits {
+		result_list = append(result_list, docFromHit(hit.Hit))
+	}
+	return result_list, total, nil
+}
+
+// 查询并重新排序
+// 窗口内的分页先取回整个窗口排好再截取，保证翻页顺序一致；窗口外的按zincsearch的顺序
+func rankedSearch(updateid int, query string, page int, pageSize int) ([]zincsearch.RankedHit, int, error) {
+	parsed := zincsearch.ParseQuery(query)
+	searchReq := &zincsearch.QueryRequest{
+		Query: visibleQuery(parsed.QueryWithSynonyms(getSynonyms())),
+		Size: pageSize,
+		From: page,
+		Sort: []string{"-_score"},
+	}
+	// 只有过滤条件时分数都一样，按人数排
+	if !parsed.HasText(){
+		searchReq.Sort = []string{"-user_count"}
+	}
+	rerank := page + pageSize <= g_iRankWindow
+	// 去重时窗口外的结果要按窗口里去掉的个数往后挪，深翻页也要先取窗口
+	collapse := g_bCollapseDuplicates && g_iRankWindow > 0
+	if rerank || collapse{
+		searchReq.From = 0
+		searchReq.Size = g_iRankWindow
+	}
+	//lib.XLogInfo(updateid, searchReq)
+	ctx, cancel := context.WithTimeout(context.Background(), zincSearchTimeout)
+	defer cancel()
+	// 限制同时请求zincsearch的数量，排队超时按繁忙处理
+	select{
+	case g_searchSem <- struct{}{}:
+		defer func(){ <-g_searchSem }()
+	case <-ctx.Done():
+		lib.XLogErr("search busy", updateid, query)
+		return nil, 0, errSearchBusy
+	}
+	result, err := zincSearcher.Query(ctx, getSearchIndex(), searchReq)
+	if err != nil {
+		lib.XLogErr("Search", updateid, err)
+		return nil, 0, err
+	}
+	lib.XLogInfo(updateid, query, result.Hits.Total.Value, len(result.Hits.Hits))
+	if !rerank && !collapse{
+		hits := make([]zincsearch.RankedHit, 0, len(result.Hits.Hits))
+		for _, hit := range result.Hits.Hits{
+			hits = append(hits, zincsearch.RankedHit{Hit: hit})
+		}
+		return hits, result.Hits.Total.Value, nil
+	}
+	hits := zincsearch.Rank(result.Hits.Hits, g_rankWeights, getRankBoosts(), time.Now())
+	total := result.Hits.Total.Value
+	// 只在重排的窗口内去重，窗口外的深翻页不去重
+	collapsed := 0
+	if collapse{
+		hits, collapsed = collapseDuplicates(hits)
+		total -= collapsed
+	}
+	var page_hits []zincsearch.RankedHit
+	if page < len(hits){
+		end := page + pageSize
+		if end > len(hits){
+			end = len(hits)
+		}
+		page_hits = hits[page:end]
+	}
+	// 去重后窗口不够这一页时从窗口后面补，第n条对应原结果的第n+collapsed条
+	if len(page_hits) == pageSize || len(result.Hits.Hits) < searchReq.Size{
+		return page_hits, total, nil
+	}
+	more := *searchReq
+	more.From = page + len(page_hits) + collapsed
+	more.Size = pageSize - len(page_hits)
+	result, err = zincSearcher.Query(ctx, getSearchIndex(), &more)
+	if err != nil {
+		lib.XLogErr("Search", updateid, err)
+		return nil, 0, err
+	}
+	for _, hit := range result.Hits.Hits{
+		page_hits = append(page_hits, zincsearch.RankedHit{Hit: hit})
+	}
+	return page_hits, total, nil
+}
+
+func docFromHit(hit zincsearch.Hit)zincsearch.Document{
+	doc := zincsearch.DocumentFromHit(hit)
+	if doc.ContactType == "yuni" || doc.ContactType == "siliao"{
+		values := strings.Split(hit.ID, "_")
+		if len(values) == 2{
+			doc.ID = values[0] + "/" + values[1]
+		}
+	}
+	return doc
+}
+
+// 管理员查看第一页每条结果的排序分数
+func explainSearch(updateid int, msg *model.Message, query string){
+	if query == ""{
+		replyText(msg.Chat.ID, msg.MessageID, "格式: /explain 关键词")
+		return
+	}
+	hits, total, err := rankedSearch(updateid, query, 0, g_iPageCount)
+	if err != nil{
+		replyText(msg.Chat.ID, msg.MessageID, "搜索失败: " + err.Error())
+		return
+	}
+	text := fmt.Sprintf("%s 共%d条 %s\n", zincsearch.ParseQuery(query).String(), total, getSearchIndex())
+	for i, hit := range hits{
+		doc := docFromHit(hit.Hit)
+		text += fmt.Sprintf("%d. %s [%s] %d人\n%s\n", i + 1, doc.Title, hit.ID, doc.UserCount, hit.Explain.String())
+	}
+	replyText(msg.Chat.ID, msg.MessageID, text)
 }
 
 
@@ -475,31 +2592,40 @@ func replyText(chatid int64, msgid int, text string){
 }
 
 // 发送搜索结果（带分页）
-func sendSearchResults(updateid int, chatID int64, messageID int, page int, query string) {
+func sendSearchResults(updateid int, userid int64, msg *model.Message, page int, query string) {
 	msg_config := model.SendMessageConfig{}
-	msg_config.ChatID = chatID
-	msg_content, entities := fillEmtities(updateid, query, page)
+	msg_config.ChatID = msg.Chat.ID
+	if msg.IsTopicMessage{
+		msg_config.ThreadID = msg.MessageThreadID
+	}
+	msg_content, entities, rows := fillEmtities(updateid, userid, "message", query, page)
 	msg_config.Entities = entities
 	msg_config.Text = msg_content
 	msg_config.LinkPreviewOption.IsDisable = true
 
 	last_page := model.InlineKeyboardButton{Text:"⬅️上一页"}
-	last_text := query + "$$" + strconv.Itoa(g_iPageCount) + "$$" + strconv.Itoa(page - g_iPageCount)
+	last_text := pageCallbackData(query, page - g_iPageCount)
 	last_page.CallbackData = &last_text
 	next_page := model.InlineKeyboardButton{Text:"下一页➡️"}
-	next_text := query + "$$" + strconv.Itoa(g_iPageCount) + "$$" + strconv.Itoa(page + g_iPageCount)
+	next_text := pageCallbackData(query, page + g_iPageCount)
 	next_page.CallbackData = &next_text
 	var buttons []model.InlineKeyboardButton
 	buttons = append(buttons, last_page, next_page)
 
-	land_url := "tg://resolve?domain=kkhelper_bot"
-	feedback := model.InlineKeyboardButton{Text:"👣反馈搜索问题|添加频道|购买推广👣", URL: &land_url}
-
 	var markup model.InlineKeyboardMarkup
-	markup.InlineKeyboard = append(markup.InlineKeyboard, buttons, []model.InlineKeyboardButton{feedback})
+	markup.InlineKeyboard = append(rows, buttons, feedbackButtons())
+	if row := subscribeButton(query); row != nil{
+		markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+	}
 
 	msg_config.ReplyMarkup = markup
-	msg_config.ReplyParams.MessageID = messageID
+	msg_config.ReplyParams.MessageID = msg.MessageID
 
-	tb.Call(&msg_config)
+	if err := tb.Call(&msg_config); err != nil{
+		lib.XLogErr("send results", updateid, err)
+		return
+	}
+	if msg.Chat.Type != "private"{
+		trackGroupResult(msg.Chat.ID, msg_config.Response.MessageID, userid)
+	}
 }
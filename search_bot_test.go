@@ -0,0 +1,409 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"zincsearch/lib"
+	"zincsearch/model"
+	"zincsearch/zincsearch"
+)
+
+// 用内存索引代替zincsearch，返回写入用的Memory
+func setupMemorySearch(t *testing.T, docs map[string]zincsearch.Document)*zincsearch.Memory{
+	memory := zincsearch.NewMemory()
+	for id, doc := range docs{
+		doc.FillPinyin()
+		if err := memory.UpdateDocument("search_test", id, doc); err != nil{
+			t.Fatal(err)
+		}
+	}
+	old_searcher, old_index, old_sem := zincSearcher, zincIndexName, g_searchSem
+	zincSearcher = memory
+	zincIndexName = "search_test"
+	g_searchSem = make(chan struct{}, 1)
+	t.Cleanup(func(){
+		zincSearcher, zincIndexName, g_searchSem = old_searcher, old_index, old_sem
+	})
+	return memory
+}
+
+func hitIDs(hits []zincsearch.RankedHit)[]string{
+	var ids []string
+	for _, hit := range hits{
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
+
+func TestRankedSearchMemory(t *testing.T){
+	setupMemorySearch(t, map[string]zincsearch.Document{
+		"xiaomei_th": {Title: "天河小美", JsName: "小美", Location: "天河", JsType: "qm", UserCount: 500},
+		"xiaomei_hz": {Title: "海珠小美", JsName: "小美", Location: "海珠", JsType: "hs", UserCount: 100},
+		"lily": {Title: "Lily", JsName: "lily", Location: "天河", JsType: "qm", UserCount: 800},
+	})
+	hits, total, err := rankedSearch(1, "小美", 0, 10)
+	if err != nil{
+		t.Fatal(err)
+	}
+	if total != 2 || len(hits) != 2{
+		t.Fatalf("total %d hits %v", total, hitIDs(hits))
+	}
+	hits, total, err = rankedSearch(1, "小美 天河", 0, 10)
+	if err != nil{
+		t.Fatal(err)
+	}
+	if len(hits) == 0 || hits[0].ID != "xiaomei_th"{
+		t.Fatalf("total %d hits %v", total, hitIDs(hits))
+	}
+	// 翻页
+	hits, _, err = rankedSearch(1, "天河", 1, 1)
+	if err != nil{
+		t.Fatal(err)
+	}
+	if len(hits) != 1{
+		t.Fatalf("page hits %v", hitIDs(hits))
+	}
+}
+
+func TestRankedSearchCollapsesDuplicates(t *testing.T){
+	setupMemorySearch(t, map[string]zincsearch.Document{
+		"xiaomei_a": {Title: "天河小美", JsName: "小美", Location: "天河区", UserCount: 500},
+		"xiaomei_b": {Title: "小美的频道", JsName: "小美", Location: "天河", UserCount: 100},
+		"xiaomei_c": {Title: "海珠小美", JsName: "小美", Location: "海珠", UserCount: 50},
+	})
+	old := g_bCollapseDuplicates
+	g_bCollapseDuplicates = true
+	defer func(){ g_bCollapseDuplicates = old }()
+
+	hits, total, err := rankedSearch(1, "小美", 0, 10)
+	if err != nil{
+		t.Fatal(err)
+	}
+	if total != 2 || len(hits) != 2{
+		t.Fatalf("total %d hits %v", total, hitIDs(hits))
+	}
+	for _, hit := range hits{
+		if hit.ID == "xiaomei_b"{
+			t.Fatalf("duplicate not collapsed: %v", hitIDs(hits))
+		}
+	}
+}
+
+func TestCollapseDuplicates(t *testing.T){
+	hit := func(id, js_name, location string)zincsearch.RankedHit{
+		return zincsearch.RankedHit{Hit: zincsearch.Hit{ID: id, Source: map[string]interface{}{"js_name": js_name, "location": location}}}
+	}
+	hits := []zincsearch.RankedHit{
+		hit("a", "小美", "天河区"),
+		hit("b", "小美", "天河"),
+		hit("c", "", ""),
+		hit("d", "", ""),
+		hit("e", "小美", "天河"),
+	}
+	old := g_mapDedupKeep
+	defer func(){ g_mapDedupKeep = old }()
+
+	g_mapDedupKeep = map[string]bool{}
+	result, collapsed := collapseDuplicates(hits)
+	if collapsed != 2 || len(result) != 3 || result[0].ID != "a" || result[1].ID != "c" || result[2].ID != "d"{
+		t.Fatalf("collapsed %d result %v", collapsed, hitIDs(result))
+	}
+
+	// 管理员确认不是重复的文档单独显示
+	g_mapDedupKeep = map[string]bool{"e": true}
+	result, collapsed = collapseDuplicates(hits)
+	if collapsed != 1 || len(result) != 4 || result[3].ID != "e"{
+		t.Fatalf("collapsed %d result %v", collapsed, hitIDs(result))
+	}
+}
+
+func TestRankedSearchExcludesHidden(t *testing.T){
+	memory := setupMemorySearch(t, map[string]zincsearch.Document{
+		"xiaomei_th": {Title: "天河小美", UserCount: 500},
+		"xiaomei_hz": {Title: "海珠小美", UserCount: 100, Hidden: true},
+	})
+	hits, total, err := rankedSearch(1, "小美", 0, 10)
+	if err != nil{
+		t.Fatal(err)
+	}
+	if total != 1 || len(hits) != 1 || hits[0].ID != "xiaomei_th"{
+		t.Fatalf("total %d hits %v", total, hitIDs(hits))
+	}
+	// 恢复后重新出现
+	memory.UpdateFields("search_test", "xiaomei_hz", map[string]interface{}{"hidden": false})
+	if _, total, _ = rankedSearch(1, "小美", 0, 10); total != 2{
+		t.Fatalf("total %d", total)
+	}
+}
+
+func TestCollectSubscription(t *testing.T){
+	docs := map[string]zincsearch.Document{}
+	for i := 1; i <= 45; i++{
+		docs[fmt.Sprintf("xiaomei%02d", i)] = zincsearch.Document{Title: "小美", CreatedAt: int64(i)}
+	}
+	// 和第20个同一秒写入
+	docs["xiaomei20b"] = zincsearch.Document{Title: "小美", CreatedAt: 20}
+	setupMemorySearch(t, docs)
+	old_size, old_pages := g_iSubscribePageSize, g_iSubscribeMaxPages
+	defer func(){ g_iSubscribePageSize, g_iSubscribeMaxPages = old_size, old_pages }()
+	g_iSubscribePageSize, g_iSubscribeMaxPages = 10, 2
+
+	type page struct{
+		first, last int64
+		count, remaining int
+	}
+	run := func(since int64)(int64, []page){
+		var pages []page
+		last, err := collectSubscription(model.SavedSearch{Query: "小美", LastNotified: since}, func(docs []zincsearch.Document, remaining int){
+			pages = append(pages, page{docs[0].CreatedAt, docs[len(docs) - 1].CreatedAt, len(docs), remaining})
+		})
+		if err != nil{
+			t.Fatal(err)
+		}
+		return last, pages
+	}
+
+	// 从早到晚推送两页，剩下的下次推送
+	// 第二页停在同一秒写入的两个文档中间，推送位置退一秒
+	last, pages := run(0)
+	if last != 19 || fmt.Sprint(pages) != fmt.Sprint([]page{{1, 10, 10, 0}, {11, 20, 10, 26}}){
+		t.Fatalf("last %d pages %v", last, pages)
+	}
+	last, pages = run(10)
+	if last != 29 || fmt.Sprint(pages) != fmt.Sprint([]page{{11, 20, 10, 0}, {20, 29, 10, 16}}){
+		t.Fatalf("last %d pages %v", last, pages)
+	}
+	last, pages = run(40)
+	if last != 45 || fmt.Sprint(pages) != fmt.Sprint([]page{{41, 45, 5, 0}}){
+		t.Fatalf("last %d pages %v", last, pages)
+	}
+	last, pages = run(45)
+	if last != 45 || len(pages) != 0{
+		t.Fatalf("last %d pages %v", last, pages)
+	}
+}
+
+func TestPageCallbackData(t *testing.T){
+	short := pageCallbackData("小美 天河", 10)
+	if short != "小美 天河$$" + fmt.Sprint(g_iPageCount) + "$$10"{
+		t.Fatalf("data %s", short)
+	}
+	if query, ok := pageQuery("小美 天河"); !ok || query != "小美 天河"{
+		t.Fatalf("query %s", query)
+	}
+	long := "天河 海珠 越秀 荔湾 白云 番禺 黄埔 花都 增城 从化 南沙"
+	data := pageCallbackData(long, 20)
+	if len(data) > 64{
+		t.Fatalf("data too long: %d %s", len(data), data)
+	}
+	values := strings.Split(data, "$$")
+	if len(values) != 3 || values[2] != "20" || len(values[0]) != 17 || values[0][0] != '~'{
+		t.Fatalf("data %s", data)
+	}
+	// 同一个关键词生成同样的key，翻页不会产生新的redis key
+	if pageCallbackData(long, 30) != values[0] + "$$" + values[1] + "$$30"{
+		t.Fatal("short key changed")
+	}
+}
+
+func TestMatchTopFeeds(t *testing.T){
+	now := int64(1700000000)
+	feed := func(chat, keyword string, order int)model.AdFeed{
+		return model.AdFeed{ChatID: chat, Keyword: keyword, Order: order, TS: now + 3600}
+	}
+	all := map[string][]model.AdFeed{
+		"天河": {feed("tianhe", "天河", 1)},
+		"海珠 qm": {feed("haizhu", "海珠 qm", 1)},
+		"越秀": {feed("yuexiu", "越秀", 1)},
+		"白": {feed("bai", "白", 1)},
+	}
+	cases := []struct{
+		keyword string
+		want []string
+	}{
+		{"天河", []string{"tianhe"}},
+		{"天河区", []string{"tianhe"}},
+		{"广州天河区找人", []string{"tianhe"}},
+		{"海珠区 qm", []string{"haizhu"}},
+		{"海珠区", nil},
+		// 单个字只能完全相同
+		{"白云区", nil},
+		{"番禺", nil},
+	}
+	for _, c := range cases{
+		var got []string
+		for _, v := range matchTopFeeds(c.keyword, all, nil, now){
+			got = append(got, v.ChatID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want){
+			t.Errorf("%s: got %v, want %v", c.keyword, got, c.want)
+		}
+	}
+}
+
+func TestValidPreCheckout(t *testing.T){
+	order := model.AdOrder{ID: "o1", UserID: 100, Step: "invoiced", Amount: 300}
+	valid := func()*model.PreCheckoutQuery{
+		return &model.PreCheckoutQuery{ID: "q1", From: &model.User{ID: 100}, Currency: "XTR", TotalAmount: 300, InvoicePayload: "adorder:o1"}
+	}
+	if !validPreCheckout(valid(), order){
+		t.Fatal("valid query rejected")
+	}
+	cases := map[string]func(q *model.PreCheckoutQuery, o *model.AdOrder){
+		"no user": func(q *model.PreCheckoutQuery, o *model.AdOrder){ q.From = nil },
+		"other user": func(q *model.PreCheckoutQuery, o *model.AdOrder){ q.From.ID = 101 },
+		"paid": func(q *model.PreCheckoutQuery, o *model.AdOrder){ o.Step = "paid" },
+		"not invoiced": func(q *model.PreCheckoutQuery, o *model.AdOrder){ o.Step = "confirm" },
+		"currency": func(q *model.PreCheckoutQuery, o *model.AdOrder){ q.Currency = "USD" },
+		"amount": func(q *model.PreCheckoutQuery, o *model.AdOrder){ q.TotalAmount = 100 },
+	}
+	for name, change := range cases{
+		q, o := valid(), order
+		change(q, &o)
+		if validPreCheckout(q, o){
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestApplyAdOrder(t *testing.T){
+	now := int64(1700000000)
+	day := int64(24 * 3600)
+	order := model.AdOrder{ID: "o1", Kind: "topfeed", Keyword: "天河", ChatID: "tianhe", Title: "天河", Days: 7}
+	var list model.AdFeedList
+	list.Feeds = append(list.Feeds, model.AdFeed{ChatID: "other", TS: now + day})
+	end := applyAdOrder(&list, order, now)
+	if end != now + 7 * day || len(list.Feeds) != 2{
+		t.Fatalf("end %d feeds %v", end, list.Feeds)
+	}
+	feed := list.Feeds[1]
+	if feed.ChatID != "tianhe" || feed.Keyword != "天河" || feed.Start != now || feed.TS != end || feed.Order != g_iAdOrderWeight{
+		t.Fatalf("feed %+v", feed)
+	}
+	// 还在推广期内的顺延，不新增记录
+	end = applyAdOrder(&list, order, now + day)
+	if end != now + 14 * day || len(list.Feeds) != 2 || list.Feeds[1].TS != end{
+		t.Fatalf("extend end %d feeds %v", end, list.Feeds)
+	}
+	// 已经过期的重新开始
+	end = applyAdOrder(&list, order, now + 30 * day)
+	if end != now + 37 * day || len(list.Feeds) != 3{
+		t.Fatalf("renew end %d feeds %v", end, list.Feeds)
+	}
+}
+
+func TestSearchCacheDisabled(t *testing.T){
+	ttl, cache := g_cacheTTL, g_searchCache
+	defer func(){
+		g_cacheTTL, g_searchCache = ttl, cache
+	}()
+	g_searchCache = lib.NewLRU(100, time.Minute)
+	g_cacheTTL = 0
+	searchCacheAdd("k", 1)
+	if g_searchCache.Len() != 0{
+		t.Fatal("cache written with ttl 0")
+	}
+	g_searchCache.Add("k", 1)
+	if _, ok := searchCacheGet("k"); ok{
+		t.Fatal("cache read with ttl 0")
+	}
+	g_cacheTTL = time.Minute
+	searchCacheAdd("k2", 2)
+	if value, ok := searchCacheGet("k2"); !ok || value != 2{
+		t.Fatalf("cache miss with ttl on: %v", value)
+	}
+}
+
+func TestGetCacheVersionCached(t *testing.T){
+	version, at := g_sCacheVersion, g_cacheVersionAt
+	defer func(){
+		g_sCacheVersion, g_cacheVersionAt = version, at
+	}()
+	// 间隔内不读redis，直接返回上次的版本号
+	g_sCacheVersion = "42"
+	g_cacheVersionAt = time.Now()
+	if v := getCacheVersion(); v != "42"{
+		t.Fatalf("version %s", v)
+	}
+}
+
+func TestResultQuery(t *testing.T){
+	message := func(data ...string)*model.Message{
+		var row []model.InlineKeyboardButton
+		for i := range data{
+			row = append(row, model.InlineKeyboardButton{Text: data[i], CallbackData: &data[i]})
+		}
+		return &model.Message{ReplyMarkup: &model.InlineKeyboardMarkup{InlineKeyboard: [][]model.InlineKeyboardButton{row}}}
+	}
+	sub := "sub$$" + zincsearch.ParseQuery("小美 天河").String()
+	cases := []struct{
+		msg *model.Message
+		want string
+	}{
+		{nil, ""},
+		{&model.Message{}, ""},
+		{message("d$$a"), ""},
+		{message("d$$a", sub), zincsearch.ParseQuery("小美 天河").String()},
+		{message("d$$a", pageCallbackData("小美 天河", 10)), zincsearch.ParseQuery("小美 天河").String()},
+	}
+	for i, c := range cases{
+		if got := resultQuery(c.msg); got != c.want{
+			t.Errorf("case %d: got %q, want %q", i, got, c.want)
+		}
+	}
+}
+
+func TestIsResultOwnerWithoutRecord(t *testing.T){
+	callback := &model.CallbackQuery{
+		From: &model.User{ID: 100},
+		Message: &model.Message{MessageID: 1, Chat: &model.Chat{ID: -1001, Type: "supergroup"}},
+	}
+	// 发起人记录过期后不能把所有人都挡住
+	if !isResultOwner(callback){
+		t.Fatal("expired owner record blocked the callback")
+	}
+	callback.Message.Chat.Type = "private"
+	if !isResultOwner(callback){
+		t.Fatal("private chat blocked")
+	}
+}
+
+func TestRankedSearchCollapsedPaging(t *testing.T){
+	setupMemorySearch(t, map[string]zincsearch.Document{
+		"d1": {Title: "一", JsName: "小美", Location: "天河", JsType: "qm", UserCount: 1000},
+		"d2": {Title: "二", JsName: "小美", Location: "天河", JsType: "qm", UserCount: 900},
+		"d3": {Title: "三", JsName: "lily", Location: "天河", JsType: "qm", UserCount: 800},
+		"d4": {Title: "四", JsName: "amy", Location: "天河", JsType: "qm", UserCount: 700},
+		"d5": {Title: "五", JsName: "coco", Location: "天河", JsType: "qm", UserCount: 600},
+		"d6": {Title: "六", JsName: "momo", Location: "天河", JsType: "qm", UserCount: 500},
+	})
+	old_collapse, old_window, old_keep := g_bCollapseDuplicates, g_iRankWindow, g_mapDedupKeep
+	g_bCollapseDuplicates, g_iRankWindow, g_mapDedupKeep = true, 4, map[string]bool{}
+	defer func(){ g_bCollapseDuplicates, g_iRankWindow, g_mapDedupKeep = old_collapse, old_window, old_keep }()
+
+	pages := func(want_total int)string{
+		var all []string
+		for page := 0; page < 6; page += 2{
+			hits, total, err := rankedSearch(1, "type:qm", page, 2)
+			if err != nil{
+				t.Fatal(err)
+			}
+			if total != want_total{
+				t.Fatalf("page %d total %d", page, total)
+			}
+			all = append(all, hitIDs(hits)...)
+		}
+		return strings.Join(all, ",")
+	}
+	// 窗口里去掉一条后，窗口最后一页和窗口外的页从后面补上，不重复也不漏
+	if got := pages(5); got != "d1,d3,d4,d5,d6"{
+		t.Fatalf("pages %s", got)
+	}
+	// 窗口里没有重复时窗口外照常翻页
+	g_mapDedupKeep = map[string]bool{"d2": true}
+	if got := pages(6); got != "d1,d2,d3,d4,d5,d6"{
+		t.Fatalf("pages %s", got)
+	}
+}
@@ -0,0 +1,262 @@
+package zincsearch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"zincsearch/lib"
+)
+
+type aliasAction struct {
+	Add    *aliasItem `json:"add,omitempty"`
+	Remove *aliasItem `json:"remove,omitempty"`
+}
+
+type aliasItem struct {
+	Index string `json:"index"`
+	Alias string `json:"alias"`
+}
+
+type aliasRequest struct {
+	Actions []aliasAction `json:"actions"`
+}
+
+type aliasResponse map[string]struct {
+	Aliases map[string]interface{} `json:"aliases"`
+}
+
+// 给索引添加别名
+func (c *Client) CreateAlias(indexName, alias string) error {
+	req := &aliasRequest{Actions: []aliasAction{
+		{Add: &aliasItem{Index: indexName, Alias: alias}},
+	}}
+	return c.updateAliases(req)
+}
+
+// 删除索引的别名
+func (c *Client) DeleteAlias(indexName, alias string) error {
+	req := &aliasRequest{Actions: []aliasAction{
+		{Remove: &aliasItem{Index: indexName, Alias: alias}},
+	}}
+	return c.updateAliases(req)
+}
+
+// 把别名从旧索引切到新索引，在同一个请求里完成，切换过程中别名始终可用
+func (c *Client) SwapAlias(alias, oldIndex, newIndex string) error {
+	req := &aliasRequest{}
+	if oldIndex != "" {
+		req.Actions = append(req.Actions, aliasAction{Remove: &aliasItem{Index: oldIndex, Alias: alias}})
+	}
+	req.Actions = append(req.Actions, aliasAction{Add: &aliasItem{Index: newIndex, Alias: alias}})
+	return c.updateAliases(req)
+}
+
+func (c *Client) updateAliases(req *aliasRequest) error {
+	url := fmt.Sprintf("%s/es/_aliases", c.baseURL)
+	return c.doRequest("POST", url, req, nil)
+}
+
+// 查询别名指向的索引，别名不存在时返回空列表
+func (c *Client) ResolveAlias(alias string) ([]string, error) {
+	url := fmt.Sprintf("%s/es/_alias/%s", c.baseURL, alias)
+	var response aliasResponse
+	if err := c.doRequest("GET", url, nil, &response); err != nil {
+		return nil, err
+	}
+	var indexes []string
+	for index, item := range response {
+		if _, ok := item.Aliases[alias]; ok {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Strings(indexes)
+	return indexes, nil
+}
+
+// 别名解析成实际索引，别名不存在时原样返回
+// 查询失败或者别名指向多个索引时返回错误，避免写到错误的索引
+func (c *Client) ResolveIndex(name string) (string, error) {
+	indexes, err := c.ResolveAlias(name)
+	if err != nil {
+		if isNotFound(err) {
+			return name, nil
+		}
+		return "", err
+	}
+	switch len(indexes) {
+	case 0:
+		return name, nil
+	case 1:
+		return indexes[0], nil
+	}
+	return "", fmt.Errorf("alias %s points to %d indexes: %s", name, len(indexes), strings.Join(indexes, ","))
+}
+
+// 统计索引的文档数
+func (c *Client) Count(indexName string) (int, error) {
+	req := &SearchRequest{
+		SearchType: "matchall",
+		MaxResults: 1,
+	}
+	rsp, err := c.Search(indexName, req)
+	if err != nil {
+		return 0, err
+	}
+	return rsp.Hits.Total.Value, nil
+}
+
+// 新索引写入是异步落盘的，校验数量时最多等待这么久
+const reindexVerifyTimeout = 30 * time.Second
+
+// 零停机重建：把别名当前指向的索引拷贝到新索引，校验数量一致后切换别名
+// 拷贝期间通过这个客户端写入旧索引的文档同时写到新索引，失败时删除新索引
+// 双写只在当前进程内，其它进程或者其它Client在重建期间的写入会丢，重建前要先停掉
+// 旧索引保留用于回滚，返回新索引名
+func (c *Client) ReindexAlias(alias string, schema *IndexSettings) (newIndex string, err error) {
+	indexes, err := c.ResolveAlias(alias)
+	if err != nil {
+		return "", err
+	}
+	if len(indexes) != 1 {
+		return "", fmt.Errorf("alias %s points to %d indexes", alias, len(indexes))
+	}
+	oldIndex := indexes[0]
+	newIndex = fmt.Sprintf("%s_%s", alias, time.Now().Format("20060102150405"))
+	if err := c.createCopy(oldIndex, newIndex, schema); err != nil {
+		return newIndex, err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if delErr := c.DeleteIndex(newIndex); delErr != nil {
+			lib.XLogErr("delete reindex target", newIndex, delErr)
+		}
+	}()
+	// 先开始双写再拷贝，拷贝过程中的写入不会丢
+	m := c.startMirror(oldIndex, newIndex)
+	defer c.stopMirror(oldIndex)
+	if _, err := c.copyDocuments(oldIndex, newIndex); err != nil {
+		return newIndex, err
+	}
+	// 拷贝的批次可能晚于双写落到新索引，把旧值盖回去，拷贝期间写过的文档按旧索引重新同步一遍
+	if err := c.syncMirror(m); err != nil {
+		return newIndex, err
+	}
+	if err := c.waitCount(oldIndex, newIndex); err != nil {
+		return newIndex, err
+	}
+	return newIndex, c.SwapAlias(alias, oldIndex, newIndex)
+}
+
+// 双写的目标索引和期间写过的文档id
+type mirror struct {
+	source string
+	index  string
+	dirty  map[string]bool
+}
+
+func (c *Client) startMirror(src, dst string) *mirror {
+	c.mirrorMutex.Lock()
+	defer c.mirrorMutex.Unlock()
+	if c.mirrors == nil {
+		c.mirrors = make(map[string]*mirror)
+	}
+	m := &mirror{source: src, index: dst, dirty: make(map[string]bool)}
+	c.mirrors[src] = m
+	return m
+}
+
+func (c *Client) stopMirror(src string) {
+	c.mirrorMutex.Lock()
+	defer c.mirrorMutex.Unlock()
+	delete(c.mirrors, src)
+}
+
+// 写入成功后用同样的方式写到双写的目标索引
+// 同步失败只记日志，syncMirror会按旧索引补上
+func (c *Client) mirrorWrite(indexName, docID string, write func(index string) error) {
+	c.mirrorMutex.Lock()
+	m, ok := c.mirrors[indexName]
+	if ok {
+		m.dirty[docID] = true
+	}
+	c.mirrorMutex.Unlock()
+	if !ok {
+		return
+	}
+	if err := write(m.index); err != nil {
+		lib.XLogErr("mirror write", m.index, docID, err)
+	}
+}
+
+// 批量写入按每个record的_id记下，整批写到双写的目标索引，没有_id的record无法同步
+func (c *Client) mirrorBulk(indexName string, records []map[string]interface{}) {
+	c.mirrorMutex.Lock()
+	m, ok := c.mirrors[indexName]
+	if ok {
+		for _, record := range records {
+			if id, _ := record["_id"].(string); id != "" {
+				m.dirty[id] = true
+			}
+		}
+	}
+	c.mirrorMutex.Unlock()
+	if !ok {
+		return
+	}
+	if err := c.bulkInsert(m.index, records); err != nil {
+		lib.XLogErr("mirror bulk", m.index, len(records), err)
+	}
+}
+
+// 把双写过的文档按旧索引的当前内容重新写到新索引
+func (c *Client) syncMirror(m *mirror) error {
+	c.mirrorMutex.Lock()
+	ids := make([]string, 0, len(m.dirty))
+	for id := range m.dirty {
+		ids = append(ids, id)
+	}
+	c.mirrorMutex.Unlock()
+	for _, id := range ids {
+		hit, err := c.GetDocument(m.source, id)
+		if err != nil {
+			return err
+		}
+		if hit == nil {
+			err = c.deleteDocument(m.index, id)
+			if isNotFound(err) {
+				err = nil
+			}
+		} else {
+			err = c.putDocument(m.index, id, recordFromHit(*hit))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 双写期间两边的数量应当一致，新索引写入是异步的，等到一致或者超时
+func (c *Client) waitCount(oldIndex, newIndex string) error {
+	deadline := time.Now().Add(reindexVerifyTimeout)
+	for {
+		oldCount, err := c.Count(oldIndex)
+		if err != nil {
+			return err
+		}
+		newCount, err := c.Count(newIndex)
+		if err != nil {
+			return err
+		}
+		if newCount == oldCount {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("count mismatch: %s=%d %s=%d", oldIndex, oldCount, newIndex, newCount)
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,154 @@
+package zincsearch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestResolveIndex(t *testing.T) {
+	cases := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"single", 200, `{"search_qm_1":{"aliases":{"search_qm":{}}}}`, "search_qm_1", false},
+		{"not_alias", 200, `{}`, "search_qm", false},
+		{"not_found", 404, `{"error":"alias not found"}`, "search_qm", false},
+		{"multiple", 200, `{"search_qm_1":{"aliases":{"search_qm":{}}},"search_qm_2":{"aliases":{"search_qm":{}}}}`, "", true},
+		{"bad_request", 400, `{"error":"bad"}`, "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+			c := NewClient(server.URL, "", "")
+			got, err := c.ResolveIndex("search_qm")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMirrorWrite(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mutex.Unlock()
+		if r.Method == "GET" {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"id not found"}`))
+			return
+		}
+		w.Write([]byte(`{"id":"9"}`))
+	}))
+	defer server.Close()
+	c := NewClient(server.URL, "", "")
+
+	m := c.startMirror("old", "new")
+	c.UpdateDocument("old", "a", Document{Title: "a"})
+	c.InsertDocument("old", Document{Title: "b"})
+	c.DeleteDocument("old", "c")
+	c.UpdateDocument("other", "d", Document{Title: "d"})
+	if err := c.syncMirror(m); err != nil {
+		t.Fatal(err)
+	}
+	c.stopMirror("old")
+	c.UpdateDocument("old", "e", Document{Title: "e"})
+
+	want := []string{
+		"PUT /api/old/_doc/a", "PUT /api/new/_doc/a",
+		"POST /api/old/_doc", "PUT /api/new/_doc/9",
+		"DELETE /api/old/_doc/c", "DELETE /api/new/_doc/c",
+		"PUT /api/other/_doc/d",
+	}
+	if got := strings.Join(requests[:len(want)], "\n"); got != strings.Join(want, "\n") {
+		t.Fatalf("requests:\n%s", got)
+	}
+	// 旧索引里都不存在，同步时按删除处理
+	synced := 0
+	for _, req := range requests[len(want):] {
+		if strings.HasPrefix(req, "GET /api/old/_doc/") {
+			synced++
+		}
+	}
+	if synced != 3 {
+		t.Fatalf("synced %d docs: %v", synced, requests)
+	}
+	if last := requests[len(requests)-1]; last != "PUT /api/old/_doc/e" {
+		t.Fatalf("write after stop mirrored: %v", requests)
+	}
+}
+
+func TestMirrorBulkInsert(t *testing.T) {
+	server, requests := recordServer(t)
+	c := NewClient(server.URL, "", "")
+
+	m := c.startMirror("old", "new")
+	records := []map[string]interface{}{{"_id": "a", "title": "a"}, {"_id": "b", "title": "b"}, {"title": "no id"}}
+	if err := c.BulkInsert("old", records); err != nil {
+		t.Fatal(err)
+	}
+	c.BulkInsert("other", records[:1])
+	c.stopMirror("old")
+	c.BulkInsert("old", records[:1])
+
+	var indexes []string
+	for _, req := range *requests {
+		indexes = append(indexes, req.Body["index"].(string))
+	}
+	if got := strings.Join(indexes, ","); got != "old,new,other,old" {
+		t.Fatalf("bulk indexes %s", got)
+	}
+	if records := (*requests)[1].Body["records"].([]interface{}); len(records) != 3 {
+		t.Fatalf("mirrored records %v", records)
+	}
+	if len(m.dirty) != 2 || !m.dirty["a"] || !m.dirty["b"] {
+		t.Fatalf("dirty %v", m.dirty)
+	}
+}
+
+func TestReindexAliasDeletesNewIndexOnFailure(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/es/_alias/search_qm":
+			w.Write([]byte(`{"search_qm_1":{"aliases":{"search_qm":{}}}}`))
+		case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/api/index/"):
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/api/index/"))
+			w.Write([]byte(`{}`))
+		case strings.HasSuffix(r.URL.Path, "/_search"):
+			w.Write([]byte(`{"hits":{"total":{"value":1},"hits":[{"_id":"a","_source":{"title":"a"}}]}}`))
+		case r.URL.Path == "/api/_bulkv2":
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":"bulk failed"}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+	c := NewClient(server.URL, "", "")
+	newIndex, err := c.ReindexAlias("search_qm", DocumentSchema())
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(deleted) != 1 || deleted[0] != newIndex {
+		t.Fatalf("deleted %v, new index %s", deleted, newIndex)
+	}
+	if len(c.mirrors) != 0 {
+		t.Fatalf("mirror not stopped: %v", c.mirrors)
+	}
+}
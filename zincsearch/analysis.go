@@ -0,0 +1,155 @@
+package zincsearch
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+)
+
+const (
+	// zincsearch内置的gse中文分词，需要开启ZINC_PLUGIN_GSE_ENABLE
+	CJKAnalyzer       = "gse_standard"
+	CJKSearchAnalyzer = "gse_search"
+	// 拼音字段按ngram切分，输入任意连续的拼音或者首字母都能命中
+	PinyinAnalyzer       = "pinyin_ngram"
+	PinyinSearchAnalyzer = "pinyin_query"
+	// ngram的长度范围，单个字母命中太多，至少输入两个字母才匹配拼音
+	pinyinMinGram = 2
+	pinyinMaxGram = 20
+)
+
+// Document索引需要的自定义分词器
+func DocumentSettings() *Settings {
+	return &Settings{
+		Analysis: map[string]interface{}{
+			"analyzer": map[string]interface{}{
+				PinyinAnalyzer: map[string]interface{}{
+					"type":         "custom",
+					"tokenizer":    PinyinAnalyzer,
+					"token_filter": []string{"lowercase"},
+				},
+				PinyinSearchAnalyzer: map[string]interface{}{
+					"type":         "custom",
+					"tokenizer":    "whitespace",
+					"token_filter": []string{"lowercase"},
+				},
+			},
+			"tokenizer": map[string]interface{}{
+				PinyinAnalyzer: map[string]interface{}{
+					"type":        "ngram",
+					"min_gram":    pinyinMinGram,
+					"max_gram":    pinyinMaxGram,
+					"token_chars": []string{"letter", "digit"},
+				},
+			},
+		},
+	}
+}
+
+// Document的mapping和分词器
+func DocumentSchema() *IndexSettings {
+	return &IndexSettings{
+		Mappings: DocumentMapping(),
+		Settings: DocumentSettings(),
+	}
+}
+
+// 转成全拼和首字母，汉字之外的字母数字转小写保留，其它字符作为分隔
+// 例: "广州 Lily" => "guangzhou lily", "gz l"
+func ToPinyin(text string) (string, string) {
+	args := pinyin.NewArgs()
+	var full, initials strings.Builder
+	sep := func() {
+		if full.Len() > 0 && !strings.HasSuffix(full.String(), " ") {
+			full.WriteByte(' ')
+			initials.WriteByte(' ')
+		}
+	}
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) {
+			items := pinyin.SinglePinyin(r, args)
+			if len(items) == 0 || items[0] == "" {
+				sep()
+				continue
+			}
+			full.WriteString(items[0])
+			initials.WriteByte(items[0][0])
+			continue
+		}
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			lower := unicode.ToLower(r)
+			full.WriteRune(lower)
+			initials.WriteRune(lower)
+			continue
+		}
+		sep()
+	}
+	return strings.TrimSpace(full.String()), strings.TrimSpace(initials.String())
+}
+
+// 写入前生成title和js_name的拼音字段
+func (d *Document) FillPinyin() {
+	d.TitlePinyin, d.TitleInitials = ToPinyin(d.Title)
+	d.JsNamePinyin, d.JsNameInitials = ToPinyin(d.JsName)
+}
+
+// 拼音字段和来源字段
+var pinyinFields = []struct {
+	source, full, initials string
+}{
+	{"title", "title_pinyin", "title_initials"},
+	{"js_name", "js_name_pinyin", "js_name_initials"},
+}
+
+// 按_source里的title和js_name补齐拼音字段，用于旧文档回填，有变化时返回true
+func fillPinyinSource(source map[string]interface{}) bool {
+	changed := false
+	for _, field := range pinyinFields {
+		text, _ := source[field.source].(string)
+		full, initials := ToPinyin(text)
+		for k, v := range map[string]string{field.full: full, field.initials: initials} {
+			old, _ := source[k].(string)
+			if old == v {
+				continue
+			}
+			if v == "" {
+				delete(source, k)
+			} else {
+				source[k] = v
+			}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// 输入里有汉字
+func hasHan(text string) bool {
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// 关键词搜索：中文字段走gse分词，输入是拼音或者首字母时再匹配拼音字段
+// 中文输入转成拼音会命中同音字，不扩展
+func KeywordQuery(keyword string) Query {
+	b := &BoolQuery{Should: []Query{
+		MultiMatch(keyword, "title", "js_name", "description", "location", "tags"),
+	}}
+	if hasHan(keyword) {
+		return b.Query()
+	}
+	// 非中文输入ToPinyin只做小写和切分
+	full, _ := ToPinyin(keyword)
+	if full == "" {
+		return b.Query()
+	}
+	for _, field := range pinyinFields {
+		b.Should = append(b.Should, Match(field.full, full), Match(field.initials, full))
+	}
+	return b.Query()
+}
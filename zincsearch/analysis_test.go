@@ -0,0 +1,63 @@
+package zincsearch
+
+import (
+	"context"
+	"testing"
+)
+
+func shouldClauses(q Query) int {
+	b := q["bool"].(map[string]interface{})
+	return len(b["should"].([]Query))
+}
+
+func TestKeywordQueryPinyinOnlyForLatin(t *testing.T) {
+	cases := []struct {
+		keyword string
+		want    int
+	}{
+		{"广州", 1},
+		{"广州 lily", 1},
+		{"gz", 5},
+		{"guangzhou", 5},
+		{"!!", 1},
+	}
+	for _, tc := range cases {
+		if got := shouldClauses(KeywordQuery(tc.keyword)); got != tc.want {
+			t.Errorf("%q: %d clauses, want %d", tc.keyword, got, tc.want)
+		}
+	}
+}
+
+func TestFillPinyinSource(t *testing.T) {
+	source := map[string]interface{}{"title": "Lily GZ", "js_name": "", "title_initials": "stale"}
+	if !fillPinyinSource(source) {
+		t.Fatal("expected change")
+	}
+	if source["title_pinyin"] != "lily gz" || source["title_initials"] != "lily gz" {
+		t.Fatalf("source %v", source)
+	}
+	if _, ok := source["js_name_pinyin"]; ok {
+		t.Fatalf("empty js_name should not add pinyin: %v", source)
+	}
+	if fillPinyinSource(source) {
+		t.Fatal("second fill should be a no-op")
+	}
+}
+
+func TestPinyinMinGram(t *testing.T) {
+	m := NewMemory()
+	doc := Document{Title: "Lily"}
+	doc.FillPinyin()
+	if err := m.UpdateDocument("idx", "1", doc); err != nil {
+		t.Fatal(err)
+	}
+	for keyword, want := range map[string]int{"l": 0, "li": 1, "lily": 1} {
+		rsp, err := m.Query(context.Background(), "idx", &QueryRequest{Query: Match("title_pinyin", keyword), Size: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(rsp.Hits.Hits); got != want {
+			t.Errorf("%q: %d hits, want %d", keyword, got, want)
+		}
+	}
+}
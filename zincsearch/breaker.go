@@ -0,0 +1,66 @@
+package zincsearch
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// 熔断打开时请求直接返回的错误
+var ErrCircuitOpen = errors.New("zincsearch circuit open")
+
+// 连续失败threshold次后熔断cooldown时间，之后放一个请求探测，成功则恢复
+type breaker struct {
+	mutex     sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+func (b *breaker) allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// ok为false表示zincsearch不可用，4xx之类的业务错误算成功
+func (b *breaker) done(ok bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// 请求因为调用方的context结束而失败，不能说明zincsearch是否可用，只释放探测名额
+func (b *breaker) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+// 熔断是否打开
+func (c *Client) Unavailable() bool {
+	c.breaker.mutex.Lock()
+	defer c.breaker.mutex.Unlock()
+	return c.breaker.failures >= c.breaker.threshold && time.Now().Before(c.breaker.openUntil)
+}
@@ -0,0 +1,80 @@
+package zincsearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBreakerIgnoresCallerDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(`{"hits":{"total":{"value":0},"hits":[]}}`))
+	}))
+	defer server.Close()
+
+	c := NewClientWithConfig(server.URL, "", "", ClientConfig{BreakerThreshold: 2, MaxRetries: -1})
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		_, err := c.Query(ctx, "idx", &QueryRequest{Query: MatchAll()})
+		cancel()
+		if err == nil {
+			t.Fatal("expected deadline error")
+		}
+	}
+	if c.Unavailable() {
+		t.Fatal("caller deadlines opened the breaker")
+	}
+	if _, err := c.Query(context.Background(), "idx", &QueryRequest{Query: MatchAll()}); err != nil {
+		t.Fatalf("healthy server rejected: %v", err)
+	}
+}
+
+func TestBreakerOpensOnServerErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	c := NewClientWithConfig(server.URL, "", "", ClientConfig{BreakerThreshold: 2, MaxRetries: -1})
+	for i := 0; i < 2; i++ {
+		c.Query(context.Background(), "idx", &QueryRequest{Query: MatchAll()})
+	}
+	if !c.Unavailable() {
+		t.Fatal("breaker should open after server errors")
+	}
+	if _, err := c.Query(context.Background(), "idx", &QueryRequest{Query: MatchAll()}); err != ErrCircuitOpen {
+		t.Fatalf("got %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestBreakerOpensOnHungServer(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	// 客户端超时比调用方短，服务卡住时先由客户端超时
+	c := NewClientWithConfig(server.URL, "", "", ClientConfig{Timeout: 20 * time.Millisecond, BreakerThreshold: 2, MaxRetries: -1})
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		_, err := c.Query(ctx, "idx", &QueryRequest{Query: MatchAll()})
+		cancel()
+		if err == nil {
+			t.Fatal("expected timeout error")
+		}
+	}
+	if !c.Unavailable() {
+		t.Fatal("breaker should open when the server hangs")
+	}
+	if _, err := c.Query(context.Background(), "idx", &QueryRequest{Query: MatchAll()}); err != ErrCircuitOpen {
+		t.Fatalf("got %v, want ErrCircuitOpen", err)
+	}
+}
@@ -0,0 +1,166 @@
+package zincsearch
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// 描述和标签里提到的telegram用户名，@name 或 t.me/name
+var handlePattern = regexp.MustCompile(`(?:@|t\.me/)([A-Za-z][A-Za-z0-9_]{3,31})`)
+
+const (
+	// 超过这么多文档提到的用户名是公共的联系方式或者推广频道，不用来判断重复
+	maxHandleDocs = 3
+	// 一组最多这么多文档，避免一个误判的关联把大量文档串到一起
+	maxDuplicateGroup = 5
+)
+
+// 名字规范化：全角转半角，转小写，只保留字母和数字
+func NormalizeName(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// 地区规范化，去掉"区""市""县"后缀，天河区和天河算同一个地区
+func normalizeLocation(s string) string {
+	location := NormalizeName(s)
+	for _, suffix := range []string{"区", "市", "县"} {
+		if trimmed := strings.TrimSuffix(location, suffix); len([]rune(trimmed)) >= 2 {
+			location = trimmed
+		}
+	}
+	return location
+}
+
+// 同一个人的指纹相同：规范化后的js_name和地区，没有js_name时为空
+func Fingerprint(doc Document) string {
+	name := NormalizeName(doc.JsName)
+	if name == "" {
+		return ""
+	}
+	return name + "|" + normalizeLocation(doc.Location)
+}
+
+// 文档关联的telegram用户名，telegram频道是文档id本身，加上描述和标签里提到的
+func ContactHandles(doc Document) []string {
+	var handles []string
+	if (doc.ContactType == "" || doc.ContactType == "telegram") && doc.ID != "" {
+		handles = append(handles, strings.ToLower(strings.TrimPrefix(doc.ID, "@")))
+	}
+	for _, text := range []string{doc.Description, doc.Tags} {
+		for _, match := range handlePattern.FindAllStringSubmatch(text, -1) {
+			handle := strings.ToLower(match[1])
+			if !containsHandle(handles, handle) {
+				handles = append(handles, handle)
+			}
+		}
+	}
+	return handles
+}
+
+func containsHandle(handles []string, handle string) bool {
+	for _, v := range handles {
+		if v == handle {
+			return true
+		}
+	}
+	return false
+}
+
+// 不用来关联文档的用户名：机器人、ignore里的管理员和自己的频道
+func commonHandle(handle string, ignore map[string]bool) bool {
+	return strings.HasSuffix(handle, "bot") || ignore[handle]
+}
+
+// 指纹相同或者有相同用户名的文档分到一组，只返回两个以上的组
+// 机器人、ignore里的用户名和超过maxHandleDocs个文档提到的用户名不参与关联，每组最多maxDuplicateGroup个
+// 组内按人数从多到少排，第一个作为合并时保留的主文档
+func GroupDuplicates(docs []Document, ignore map[string]bool) [][]Document {
+	handles := make([][]string, len(docs))
+	handleDocs := make(map[string]int)
+	for i, doc := range docs {
+		handles[i] = ContactHandles(doc)
+		for _, handle := range handles[i] {
+			handleDocs[handle]++
+		}
+	}
+	parent := make([]int, len(docs))
+	size := make([]int, len(docs))
+	for i := range parent {
+		parent[i] = i
+		size[i] = 1
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(i, j int) bool {
+		a, b := find(i), find(j)
+		if a == b {
+			return true
+		}
+		if size[a]+size[b] > maxDuplicateGroup {
+			return false
+		}
+		if a > b {
+			a, b = b, a
+		}
+		parent[b] = a
+		size[a] += size[b]
+		return true
+	}
+	seen := make(map[string]int)
+	link := func(key string, i int) {
+		// 原来的组满了，后面的文档另起一组
+		if first, ok := seen[key]; !ok || !union(first, i) {
+			seen[key] = i
+		}
+	}
+	for i, doc := range docs {
+		if fp := Fingerprint(doc); fp != "" {
+			link("fp:"+fp, i)
+		}
+		for _, handle := range handles[i] {
+			if handleDocs[handle] <= maxHandleDocs && !commonHandle(handle, ignore) {
+				link("handle:"+handle, i)
+			}
+		}
+	}
+	members := make(map[int][]Document)
+	var roots []int
+	for i, doc := range docs {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], doc)
+	}
+	var groups [][]Document
+	for _, root := range roots {
+		group := members[root]
+		if len(group) < 2 {
+			continue
+		}
+		sort.SliceStable(group, func(i, j int) bool {
+			if group[i].UserCount != group[j].UserCount {
+				return group[i].UserCount > group[j].UserCount
+			}
+			return group[i].ID < group[j].ID
+		})
+		groups = append(groups, group)
+	}
+	return groups
+}
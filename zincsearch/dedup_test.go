@@ -0,0 +1,94 @@
+package zincsearch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func groupIDs(groups [][]Document) []string {
+	var result []string
+	for _, group := range groups {
+		var ids []string
+		for _, doc := range group {
+			ids = append(ids, doc.ID)
+		}
+		sort.Strings(ids)
+		result = append(result, strings.Join(ids, ","))
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestGroupDuplicates(t *testing.T) {
+	cases := []struct {
+		name   string
+		docs   []Document
+		ignore map[string]bool
+		want   []string
+	}{
+		{
+			name: "fingerprint",
+			docs: []Document{
+				{ID: "a", JsName: "小美", Location: "天河区", ContactType: "yuni"},
+				{ID: "b", JsName: "ＸＩＡＯ美", Location: "海珠", ContactType: "yuni"},
+				{ID: "c", JsName: "小美", Location: "天河", ContactType: "yuni", UserCount: 10},
+			},
+			want: []string{"a,c"},
+		},
+		{
+			name: "handle",
+			docs: []Document{
+				{ID: "xiaomei_ch"},
+				{ID: "other", Description: "联系 @xiaomei_ch"},
+			},
+			want: []string{"other,xiaomei_ch"},
+		},
+		{
+			name: "bot_and_ignored_handles",
+			docs: []Document{
+				{ID: "a1", Description: "预约找 @booking_bot"},
+				{ID: "a2", Description: "预约找 @booking_bot"},
+				{ID: "b1", Description: "投稿 t.me/guangzhoujs"},
+				{ID: "b2", Description: "投稿 t.me/guangzhoujs"},
+			},
+			ignore: map[string]bool{"guangzhoujs": true},
+		},
+		{
+			name: "common_handle",
+			docs: []Document{
+				{ID: "c1", Description: "@promo_channel"},
+				{ID: "c2", Description: "@promo_channel"},
+				{ID: "c3", Description: "@promo_channel"},
+				{ID: "c4", Description: "@promo_channel"},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := groupIDs(GroupDuplicates(tc.docs, tc.ignore))
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGroupDuplicatesSizeCap(t *testing.T) {
+	var docs []Document
+	for i := 0; i < 8; i++ {
+		docs = append(docs, Document{ID: fmt.Sprintf("d%d", i), JsName: "小美", Location: "天河"})
+	}
+	groups := GroupDuplicates(docs, nil)
+	total := 0
+	for _, group := range groups {
+		if len(group) > maxDuplicateGroup {
+			t.Fatalf("group size %d", len(group))
+		}
+		total += len(group)
+	}
+	if len(groups) != 2 || total != 8 {
+		t.Fatalf("groups %v", groupIDs(groups))
+	}
+}
@@ -0,0 +1,103 @@
+package zincsearch
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// 索引查询条件，支持链式调用
+// 例: NewIndex().Page(1, 20).OrderField("name").IsDesc().FindName("search")
+type Index struct {
+	ShardNum int    //分片数
+	PageNum  int    //页数
+	PageSize int    //条数
+	SortBy   string //排序字段
+	Desc     bool   //按降序排序
+	Name     string //通过名称进行模糊查询
+}
+
+type IndexPage struct {
+	PageNum  int `json:"page_num"`
+	PageSize int `json:"page_size"`
+	Total    int `json:"total"`
+}
+
+// 分页的索引列表
+type IndexList struct {
+	List []IndexSettings `json:"list"`
+	Page IndexPage       `json:"page"`
+}
+
+func NewIndex() *Index {
+	return &Index{ShardNum: 1, PageNum: 1, PageSize: 20, SortBy: "name"}
+}
+
+// 设置分片数-并行读取的能力，默认1
+func (api *Index) SetShardNum(num int) *Index {
+	api.ShardNum = num
+	return api
+}
+
+// 设置分页数据
+func (api *Index) Page(page, pagesize int) *Index {
+	api.PageNum = page
+	api.PageSize = pagesize
+	return api
+}
+
+// 设置排序字段，单个字段，如：name，默认name
+func (api *Index) OrderField(field string) *Index {
+	api.SortBy = field
+	return api
+}
+
+// 是否降序排序，默认：false
+func (api *Index) IsDesc() *Index {
+	api.Desc = true
+	return api
+}
+
+// 通过名称进行模糊查询
+func (api *Index) FindName(name string) *Index {
+	api.Name = name
+	return api
+}
+
+// 按当前分片数生成建索引的参数
+func (api *Index) Settings(indexName string, mappings *Mappings) *IndexSettings {
+	return &IndexSettings{
+		Name:           indexName,
+		NumberOfShards: api.ShardNum,
+		Storagetype:    "disk",
+		Mappings:       mappings,
+	}
+}
+
+func (api *Index) query() string {
+	values := url.Values{}
+	values.Set("page_num", strconv.Itoa(api.PageNum))
+	values.Set("page_size", strconv.Itoa(api.PageSize))
+	values.Set("sort_by", api.SortBy)
+	values.Set("desc", strconv.FormatBool(api.Desc))
+	if api.Name != "" {
+		values.Set("name", api.Name)
+	}
+	return values.Encode()
+}
+
+// 更新索引
+func (c *Client) UpdateIndex(settings *IndexSettings) error {
+	url := fmt.Sprintf("%s/api/index", c.baseURL)
+	return c.doRequest("PUT", url, settings, nil)
+}
+
+// 按条件分页列出索引
+func (c *Client) ListIndexPage(api *Index) (*IndexList, error) {
+	url := fmt.Sprintf("%s/api/index?%s", c.baseURL, api.query())
+	var list IndexList
+	if err := c.doRequest("GET", url, nil, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
@@ -0,0 +1,150 @@
+package zincsearch
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+type recordedRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Body   map[string]interface{}
+}
+
+// 记录收到的请求，返回固定的空响应
+func recordServer(t *testing.T) (*httptest.Server, *[]recordedRequest) {
+	var requests []recordedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := recordedRequest{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query()}
+		data, _ := io.ReadAll(r.Body)
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &req.Body); err != nil {
+				t.Errorf("invalid body %s: %v", data, err)
+			}
+		}
+		requests = append(requests, req)
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestListIndexPageQuery(t *testing.T) {
+	cases := []struct {
+		name  string
+		index *Index
+		want  map[string]string
+	}{
+		{"default", NewIndex(), map[string]string{"page_num": "1", "page_size": "20", "sort_by": "name", "desc": "false", "name": ""}},
+		{"page", NewIndex().Page(3, 50), map[string]string{"page_num": "3", "page_size": "50"}},
+		{"order", NewIndex().OrderField("doc_num"), map[string]string{"sort_by": "doc_num", "desc": "false"}},
+		{"desc", NewIndex().IsDesc(), map[string]string{"desc": "true"}},
+		{"name", NewIndex().FindName("search"), map[string]string{"name": "search"}},
+		{"chain", NewIndex().Page(2, 10).OrderField("name").IsDesc().FindName("qm"), map[string]string{"page_num": "2", "page_size": "10", "sort_by": "name", "desc": "true", "name": "qm"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, requests := recordServer(t)
+			c := NewClient(server.URL, "u", "p")
+			if _, err := c.ListIndexPage(tc.index); err != nil {
+				t.Fatal(err)
+			}
+			req := (*requests)[0]
+			if req.Method != "GET" || req.Path != "/api/index" {
+				t.Fatalf("got %s %s", req.Method, req.Path)
+			}
+			for k, v := range tc.want {
+				if got := req.Query.Get(k); got != v {
+					t.Errorf("%s = %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexSettingsShardNum(t *testing.T) {
+	cases := []struct {
+		name  string
+		index *Index
+		want  float64
+	}{
+		{"default", NewIndex(), 1},
+		{"shards", NewIndex().SetShardNum(3), 3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, requests := recordServer(t)
+			c := NewClient(server.URL, "", "")
+			if err := c.CreateIndex(tc.index.Settings("search_qm", DocumentMapping())); err != nil {
+				t.Fatal(err)
+			}
+			req := (*requests)[0]
+			if req.Method != "POST" || req.Path != "/api/index" {
+				t.Fatalf("got %s %s", req.Method, req.Path)
+			}
+			if req.Body["name"] != "search_qm" || req.Body["shard_num"] != tc.want || req.Body["storage_type"] != "disk" {
+				t.Fatalf("body %v", req.Body)
+			}
+			if _, ok := req.Body["mappings"]; !ok {
+				t.Fatal("missing mappings")
+			}
+		})
+	}
+}
+
+func TestDocumentMethods(t *testing.T) {
+	doc := Document{Title: "天河小美", ChatID: "xm"}
+	cases := []struct {
+		name   string
+		call   func(c *Client) error
+		method string
+		path   string
+		body   bool
+	}{
+		{"create_or_update", func(c *Client) error { return c.CreateOrUpdate("idx", "xm", doc) }, "PUT", "/api/idx/_doc/xm", true},
+		{"delete", func(c *Client) error { return c.Delete("idx", "xm") }, "DELETE", "/api/idx/_doc/xm", false},
+		{"insert", func(c *Client) error { return c.InsertDocument("idx", doc) }, "POST", "/api/idx/_doc", true},
+		{"update_fields", func(c *Client) error {
+			return c.UpdateFields("idx", "xm", map[string]interface{}{"title": "天河小美", "chat_id": "xm"})
+		}, "POST", "/api/idx/_update/xm", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, requests := recordServer(t)
+			c := NewClient(server.URL, "", "")
+			if err := tc.call(c); err != nil {
+				t.Fatal(err)
+			}
+			req := (*requests)[0]
+			if req.Method != tc.method || req.Path != tc.path {
+				t.Fatalf("got %s %s, want %s %s", req.Method, req.Path, tc.method, tc.path)
+			}
+			if tc.body && (req.Body["title"] != "天河小美" || req.Body["chat_id"] != "xm") {
+				t.Fatalf("body %v", req.Body)
+			}
+			if !tc.body && req.Body != nil {
+				t.Fatalf("unexpected body %v", req.Body)
+			}
+		})
+	}
+}
+
+func TestDefaultBaseURLOverride(t *testing.T) {
+	server, requests := recordServer(t)
+	old := DefaultBaseURL
+	DefaultBaseURL = server.URL + "/"
+	defer func() { DefaultBaseURL = old }()
+
+	c := NewClient("", "", "")
+	if err := c.Delete("idx", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*requests) != 1 || (*requests)[0].Path != "/api/idx/_doc/1" {
+		t.Fatalf("requests %v", *requests)
+	}
+}
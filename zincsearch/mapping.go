@@ -0,0 +1,245 @@
+package zincsearch
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// 索引mapping
+type Mappings struct {
+	Properties map[string]FieldSetting `json:"properties"`
+}
+
+// 索引settings
+type Settings struct {
+	NumberOfShards   int                    `json:"number_of_shards,omitempty"`
+	NumberOfReplicas int                    `json:"number_of_replicas,omitempty"`
+	Analysis         map[string]interface{} `json:"analysis,omitempty"`
+}
+
+type mappingResponse map[string]struct {
+	Mappings Mappings `json:"mappings"`
+}
+
+type settingsResponse map[string]struct {
+	Settings Settings `json:"settings"`
+}
+
+// 获取索引mapping
+func (c *Client) GetMapping(indexName string) (*Mappings, error) {
+	url := fmt.Sprintf("%s/api/%s/_mapping", c.baseURL, indexName)
+	var response mappingResponse
+	if err := c.doRequest("GET", url, nil, &response); err != nil {
+		return nil, err
+	}
+	item, ok := response[indexName]
+	if !ok {
+		return nil, fmt.Errorf("mapping of %s not found", indexName)
+	}
+	if item.Mappings.Properties == nil {
+		item.Mappings.Properties = make(map[string]FieldSetting)
+	}
+	return &item.Mappings, nil
+}
+
+// 更新索引mapping，zincsearch只允许追加字段，已有字段的类型不能修改
+func (c *Client) UpdateMapping(indexName string, mappings *Mappings) error {
+	url := fmt.Sprintf("%s/api/%s/_mapping", c.baseURL, indexName)
+	return c.doRequest("PUT", url, mappings, nil)
+}
+
+// 获取索引settings
+func (c *Client) GetSettings(indexName string) (*Settings, error) {
+	url := fmt.Sprintf("%s/api/%s/_settings", c.baseURL, indexName)
+	var response settingsResponse
+	if err := c.doRequest("GET", url, nil, &response); err != nil {
+		return nil, err
+	}
+	item, ok := response[indexName]
+	if !ok {
+		return nil, fmt.Errorf("settings of %s not found", indexName)
+	}
+	return &item.Settings, nil
+}
+
+// 更新索引settings
+func (c *Client) UpdateSettings(indexName string, settings *Settings) error {
+	url := fmt.Sprintf("%s/api/%s/_settings", c.baseURL, indexName)
+	return c.doRequest("PUT", url, settings, nil)
+}
+
+// 根据结构体的zinc tag生成mapping
+// 格式: `zinc:"类型,选项..."`，选项有index store sortable aggregatable highlightable，
+// 以及analyzer=xx search_analyzer=xx format=xx；`zinc:"-"`或者没有zinc tag的字段不入mapping
+func MappingOf(v interface{}) *Mappings {
+	mappings := &Mappings{Properties: make(map[string]FieldSetting)}
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return mappings
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("zinc")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		if name == "-" {
+			continue
+		}
+		mappings.Properties[name] = parseFieldTag(tag)
+	}
+	return mappings
+}
+
+// Document的mapping
+func DocumentMapping() *Mappings {
+	return MappingOf(Document{})
+}
+
+func parseFieldTag(tag string) FieldSetting {
+	var setting FieldSetting
+	for i, item := range strings.Split(tag, ",") {
+		item = strings.TrimSpace(item)
+		if i == 0 {
+			setting.Type = item
+			continue
+		}
+		key, value, _ := strings.Cut(item, "=")
+		switch key {
+		case "index":
+			setting.Index = true
+		case "store":
+			setting.Store = true
+		case "sortable":
+			setting.Sortable = true
+		case "aggregatable":
+			setting.Aggregatable = true
+		case "highlightable":
+			setting.Highlightable = true
+		case "analyzer":
+			setting.Analyzer = value
+		case "search_analyzer":
+			setting.SearchAnalyzer = value
+		case "format":
+			setting.Format = value
+		}
+	}
+	return setting
+}
+
+// zincsearch把es的数值类型统一存成numeric
+func normalizeType(t string) string {
+	switch t {
+	case "integer", "long", "short", "byte", "float", "double":
+		return "numeric"
+	case "boolean":
+		return "bool"
+	}
+	return t
+}
+
+// 字段变更
+type FieldChange struct {
+	Live FieldSetting
+	Want FieldSetting
+}
+
+// 期望mapping和线上mapping的差异
+type MappingDiff struct {
+	// 线上缺少的字段，可以直接追加
+	Added map[string]FieldSetting
+	// 类型、分词器或者能力不一致的字段，需要重建索引
+	Changed map[string]FieldChange
+	// 线上有但期望mapping没有的字段，zincsearch不支持删除字段，仅提示
+	Extra map[string]FieldSetting
+}
+
+func (d *MappingDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0
+}
+
+func (d *MappingDiff) NeedReindex() bool {
+	return len(d.Changed) > 0
+}
+
+func (d *MappingDiff) String() string {
+	if d.IsEmpty() && len(d.Extra) == 0 {
+		return "mapping一致"
+	}
+	text := ""
+	for _, name := range sortedKeys(d.Added) {
+		text += fmt.Sprintf("+ %s %+v\n", name, d.Added[name])
+	}
+	changed := make([]string, 0, len(d.Changed))
+	for name := range d.Changed {
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	for _, name := range changed {
+		text += fmt.Sprintf("~ %s %+v => %+v\n", name, d.Changed[name].Live, d.Changed[name].Want)
+	}
+	for _, name := range sortedKeys(d.Extra) {
+		text += fmt.Sprintf("? %s %+v\n", name, d.Extra[name])
+	}
+	return text
+}
+
+func sortedKeys(m map[string]FieldSetting) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 对比线上mapping和期望mapping
+func DiffMapping(live, want *Mappings) *MappingDiff {
+	diff := &MappingDiff{
+		Added:   make(map[string]FieldSetting),
+		Changed: make(map[string]FieldChange),
+		Extra:   make(map[string]FieldSetting),
+	}
+	for name, w := range want.Properties {
+		l, ok := live.Properties[name]
+		if !ok {
+			diff.Added[name] = w
+			continue
+		}
+		if fieldChanged(l, w) {
+			diff.Changed[name] = FieldChange{Live: l, Want: w}
+		}
+	}
+	for name, l := range live.Properties {
+		if _, ok := want.Properties[name]; !ok && !strings.HasPrefix(name, "@") && !strings.HasPrefix(name, "_") {
+			diff.Extra[name] = l
+		}
+	}
+	return diff
+}
+
+// 线上字段多出来的能力不算变更，只有期望的能力线上没有时才需要重建
+func fieldChanged(live, want FieldSetting) bool {
+	if normalizeType(live.Type) != normalizeType(want.Type) {
+		return true
+	}
+	if want.Analyzer != "" && live.Analyzer != want.Analyzer {
+		return true
+	}
+	if want.SearchAnalyzer != "" && live.SearchAnalyzer != want.SearchAnalyzer {
+		return true
+	}
+	return (want.Index && !live.Index) ||
+		(want.Sortable && !live.Sortable) ||
+		(want.Aggregatable && !live.Aggregatable) ||
+		(want.Highlightable && !live.Highlightable)
+}
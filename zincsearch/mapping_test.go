@@ -0,0 +1,117 @@
+package zincsearch
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFieldTag(t *testing.T) {
+	cases := []struct {
+		tag  string
+		want FieldSetting
+	}{
+		{"keyword", FieldSetting{Type: "keyword"}},
+		{"keyword,index,store", FieldSetting{Type: "keyword", Index: true, Store: true}},
+		{"numeric, index, sortable, aggregatable", FieldSetting{Type: "numeric", Index: true, Sortable: true, Aggregatable: true}},
+		{"text,index,highlightable,analyzer=gse_standard,search_analyzer=gse_search",
+			FieldSetting{Type: "text", Index: true, Highlightable: true, Analyzer: "gse_standard", SearchAnalyzer: "gse_search"}},
+		{"date,format=2006-01-02", FieldSetting{Type: "date", Format: "2006-01-02"}},
+		// 不认识的选项忽略
+		{"bool,unknown,index", FieldSetting{Type: "bool", Index: true}},
+	}
+	for _, tc := range cases {
+		if got := parseFieldTag(tc.tag); got != tc.want {
+			t.Errorf("%q: got %+v, want %+v", tc.tag, got, tc.want)
+		}
+	}
+}
+
+func TestMappingOf(t *testing.T) {
+	type doc struct {
+		Title   string `json:"title" zinc:"text,index"`
+		Count   int    `json:"count,omitempty" zinc:"numeric,sortable"`
+		NoJSON  string `zinc:"keyword"`
+		Skip    string `json:"skip" zinc:"-"`
+		NoTag   string `json:"no_tag"`
+		Ignored string `json:"-" zinc:"keyword"`
+	}
+	got := MappingOf(&doc{}).Properties
+	want := map[string]FieldSetting{
+		"title":  {Type: "text", Index: true},
+		"count":  {Type: "numeric", Sortable: true},
+		"NoJSON": {Type: "keyword"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if len(MappingOf("not a struct").Properties) != 0 {
+		t.Fatal("non struct should have no fields")
+	}
+}
+
+func TestDiffMapping(t *testing.T) {
+	text := FieldSetting{Type: "text", Index: true, Analyzer: "gse_standard", SearchAnalyzer: "gse_search"}
+	cases := []struct {
+		name    string
+		live    FieldSetting
+		want    FieldSetting
+		changed bool
+	}{
+		{"same", text, text, false},
+		{"es numeric type", FieldSetting{Type: "long", Index: true}, FieldSetting{Type: "numeric", Index: true}, false},
+		{"es bool type", FieldSetting{Type: "boolean"}, FieldSetting{Type: "bool"}, false},
+		{"type", FieldSetting{Type: "keyword", Index: true}, FieldSetting{Type: "text", Index: true}, true},
+		{"analyzer", FieldSetting{Type: "text", Index: true, Analyzer: "standard"}, text, true},
+		{"search analyzer", FieldSetting{Type: "text", Index: true, Analyzer: "gse_standard"}, text, true},
+		{"analyzer not wanted", text, FieldSetting{Type: "text", Index: true}, false},
+		{"index missing", FieldSetting{Type: "keyword"}, FieldSetting{Type: "keyword", Index: true}, true},
+		{"sortable missing", FieldSetting{Type: "numeric", Index: true}, FieldSetting{Type: "numeric", Index: true, Sortable: true}, true},
+		{"extra capability", FieldSetting{Type: "keyword", Index: true, Aggregatable: true}, FieldSetting{Type: "keyword", Index: true}, false},
+	}
+	for _, tc := range cases {
+		live := &Mappings{Properties: map[string]FieldSetting{"f": tc.live}}
+		want := &Mappings{Properties: map[string]FieldSetting{"f": tc.want}}
+		diff := DiffMapping(live, want)
+		if _, ok := diff.Changed["f"]; ok != tc.changed || diff.NeedReindex() != tc.changed || diff.IsEmpty() == tc.changed {
+			t.Errorf("%s: diff %s", tc.name, diff)
+		}
+	}
+}
+
+func TestDiffMappingAddedAndExtra(t *testing.T) {
+	live := &Mappings{Properties: map[string]FieldSetting{
+		"title":      {Type: "text", Index: true},
+		"old":        {Type: "keyword"},
+		"@timestamp": {Type: "date"},
+		"_id":        {Type: "keyword"},
+	}}
+	want := &Mappings{Properties: map[string]FieldSetting{
+		"title":   {Type: "text", Index: true},
+		"pinyin":  {Type: "text", Index: true},
+		"members": {Type: "numeric", Sortable: true},
+	}}
+	diff := DiffMapping(live, want)
+	if len(diff.Added) != 2 || diff.Added["pinyin"] != want.Properties["pinyin"] || diff.Added["members"] != want.Properties["members"] {
+		t.Fatalf("added %+v", diff.Added)
+	}
+	// 只追加字段不需要重建
+	if diff.IsEmpty() || diff.NeedReindex() {
+		t.Fatalf("diff %s", diff)
+	}
+	// 内置字段不算多出来的
+	if len(diff.Extra) != 1 || diff.Extra["old"] != live.Properties["old"] {
+		t.Fatalf("extra %+v", diff.Extra)
+	}
+	if !DiffMapping(want, want).IsEmpty() || DiffMapping(want, want).String() != "mapping一致" {
+		t.Fatal("same mapping should be empty")
+	}
+}
+
+func TestDocumentMappingFields(t *testing.T) {
+	mapping := DocumentMapping()
+	for _, name := range []string{"title", "js_name", "created_at", "hidden"} {
+		if _, ok := mapping.Properties[name]; !ok {
+			t.Errorf("missing %s", name)
+		}
+	}
+}
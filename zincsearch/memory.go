@@ -0,0 +1,664 @@
+package zincsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// 内存版的zincsearch，实现Searcher和Indexer，用于离线测试
+// 文本按standard分词器的规则切词：英文数字按词切分并转小写，中日韩文字逐字切分；
+// mapping里指定拼音分词器的字段按ngram匹配
+// 支持match matchphrase term prefix matchall，以及es风格的match multi_match
+// match_phrase term terms range bool match_all查询，按_score或字段排序
+type Memory struct {
+	mutex    sync.RWMutex
+	indexes  map[string]*memoryIndex
+	mappings map[string]*Mappings
+	aliases  map[string]string
+	nextID   int
+}
+
+// 和zincsearch一样在写入时切词，查询时只查表
+type memoryIndex struct {
+	docs map[string]map[string]interface{}
+	// 文档id => 字段 => 按字段分词器切好的词，_all为全部字段拼接
+	tokens map[string]map[string][]string
+	// 字段 => 词 => 包含这个词的文档数，算idf用
+	df map[string]map[string]int
+}
+
+func newMemoryIndex() *memoryIndex {
+	return &memoryIndex{
+		docs:   make(map[string]map[string]interface{}),
+		tokens: make(map[string]map[string][]string),
+		df:     make(map[string]map[string]int),
+	}
+}
+
+func (idx *memoryIndex) put(id string, source map[string]interface{}, mappings *Mappings) {
+	idx.remove(id)
+	fields := make(map[string][]string, len(source)+1)
+	for field := range source {
+		fields[field] = analyze(mappings, field, fieldText(source, field), false)
+	}
+	fields["_all"] = analyze(mappings, "_all", fieldText(source, "_all"), false)
+	for field, tokens := range fields {
+		if idx.df[field] == nil {
+			idx.df[field] = make(map[string]int)
+		}
+		for _, t := range distinct(tokens) {
+			idx.df[field][t]++
+		}
+	}
+	idx.docs[id] = source
+	idx.tokens[id] = fields
+}
+
+func (idx *memoryIndex) remove(id string) {
+	for field, tokens := range idx.tokens[id] {
+		for _, t := range distinct(tokens) {
+			if idx.df[field][t]--; idx.df[field][t] <= 0 {
+				delete(idx.df[field], t)
+			}
+		}
+	}
+	delete(idx.docs, id)
+	delete(idx.tokens, id)
+}
+
+func distinct(tokens []string) []string {
+	seen := make(map[string]bool, len(tokens))
+	result := tokens[:0:0]
+	for _, t := range tokens {
+		if !seen[t] {
+			seen[t] = true
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		indexes:  make(map[string]*memoryIndex),
+		mappings: make(map[string]*Mappings),
+		aliases:  make(map[string]string),
+	}
+}
+
+// 设置索引的mapping，未设置时使用DocumentMapping，已有的文档按新mapping重新切词
+func (m *Memory) SetMapping(indexName string, mappings *Mappings) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.mappings[indexName] = mappings
+	if idx := m.indexes[indexName]; idx != nil {
+		rebuilt := newMemoryIndex()
+		for id, source := range idx.docs {
+			rebuilt.put(id, source, mappings)
+		}
+		m.indexes[indexName] = rebuilt
+	}
+}
+
+func (m *Memory) mapping(indexName string) *Mappings {
+	if mappings := m.mappings[indexName]; mappings != nil {
+		return mappings
+	}
+	return DocumentMapping()
+}
+
+// 设置别名
+func (m *Memory) SetAlias(alias, indexName string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.aliases[alias] = indexName
+}
+
+func (m *Memory) ResolveIndex(name string) (string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.resolve(name), nil
+}
+
+func (m *Memory) resolve(name string) string {
+	if index, ok := m.aliases[name]; ok {
+		return index
+	}
+	return name
+}
+
+func (m *Memory) InsertDocument(indexName string, document interface{}) error {
+	m.mutex.Lock()
+	m.nextID++
+	id := strconv.Itoa(m.nextID)
+	m.mutex.Unlock()
+	return m.UpdateDocument(indexName, id, document)
+}
+
+func (m *Memory) UpdateDocument(indexName, docID string, document interface{}) error {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	source := make(map[string]interface{})
+	if err := json.Unmarshal(data, &source); err != nil {
+		return err
+	}
+	// 和zincsearch一样记录写入时间
+	if _, ok := source["@timestamp"]; !ok {
+		source["@timestamp"] = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	indexName = m.resolve(indexName)
+	if m.indexes[indexName] == nil {
+		m.indexes[indexName] = newMemoryIndex()
+	}
+	m.indexes[indexName].put(docID, source, m.mapping(indexName))
+	return nil
+}
+
+func (m *Memory) UpdateFields(indexName, docID string, fields map[string]interface{}) error {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	update := make(map[string]interface{})
+	if err := json.Unmarshal(data, &update); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	indexName = m.resolve(indexName)
+	idx := m.indexes[indexName]
+	if idx == nil || idx.docs[docID] == nil {
+		return &StatusError{StatusCode: 404, Message: "id not found"}
+	}
+	source := make(map[string]interface{}, len(idx.docs[docID])+len(update))
+	for k, v := range idx.docs[docID] {
+		source[k] = v
+	}
+	for k, v := range update {
+		source[k] = v
+	}
+	idx.put(docID, source, m.mapping(indexName))
+	return nil
+}
+
+func (m *Memory) DeleteDocument(indexName, docID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	idx := m.indexes[m.resolve(indexName)]
+	if idx == nil || idx.docs[docID] == nil {
+		return &StatusError{StatusCode: 404, Message: "id not found"}
+	}
+	idx.remove(docID)
+	return nil
+}
+
+func (m *Memory) Search(indexName string, req *SearchRequest) (*SearchResponse, error) {
+	return m.SearchContext(context.Background(), indexName, req)
+}
+
+// 把v1的搜索请求转成es风格的查询执行
+func (m *Memory) SearchContext(ctx context.Context, indexName string, req *SearchRequest) (*SearchResponse, error) {
+	term := fmt.Sprint(req.Query["term"])
+	field, _ := req.Query["field"].(string)
+	if field == "" {
+		field = "_all"
+	}
+	var query Query
+	switch req.SearchType {
+	case "matchall", "":
+		query = MatchAll()
+	case "match":
+		query = Match(field, term)
+	case "matchphrase":
+		query = MatchPhrase(field, term)
+	case "term":
+		query = Term(field, term)
+	case "prefix":
+		query = Query{"prefix": map[string]interface{}{field: map[string]interface{}{"value": term}}}
+	default:
+		return nil, &StatusError{StatusCode: 400, Message: "unsupported search_type " + req.SearchType}
+	}
+	return m.Query(ctx, indexName, &QueryRequest{
+		Query:  query,
+		From:   req.From,
+		Size:   req.MaxResults,
+		Sort:   req.SortFields,
+		Source: req.Source,
+	})
+}
+
+func (m *Memory) Query(ctx context.Context, indexName string, req *QueryRequest) (*SearchResponse, error) {
+	// 统一转成json解析后的结构，构造函数生成的查询和手写的map按同一种方式处理
+	var query map[string]interface{}
+	data, err := json.Marshal(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &query); err != nil {
+		return nil, err
+	}
+
+	m.mutex.RLock()
+	indexName = m.resolve(indexName)
+	e := &evaluator{index: m.indexes[indexName], mappings: m.mapping(indexName)}
+	if e.index == nil {
+		e.index = newMemoryIndex()
+	}
+	var hits []Hit
+	for id, source := range e.index.docs {
+		e.id = id
+		ok, score, err := e.match(query, source)
+		if err != nil {
+			m.mutex.RUnlock()
+			return nil, err
+		}
+		if ok {
+			hits = append(hits, Hit{ID: id, Score: score, Source: pick(source, req.Source)})
+		}
+	}
+	m.mutex.RUnlock()
+
+	sortHits(hits, req.Sort)
+	response := &SearchResponse{}
+	response.Hits.Total.Value = len(hits)
+	from, size := req.From, req.Size
+	if from < 0 {
+		from = 0
+	}
+	if from > len(hits) {
+		from = len(hits)
+	}
+	end := len(hits)
+	if size > 0 && from+size < end {
+		end = from + size
+	}
+	response.Hits.Hits = hits[from:end]
+	return response, nil
+}
+
+func pick(source map[string]interface{}, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		return source
+	}
+	result := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		if v, ok := source[f]; ok {
+			result[f] = v
+		}
+	}
+	return result
+}
+
+// 默认按_score降序，分数相同按id保证结果稳定
+func sortHits(hits []Hit, fields []string) {
+	if len(fields) == 0 {
+		fields = []string{"-_score"}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		for _, field := range fields {
+			desc := strings.HasPrefix(field, "-")
+			name := strings.TrimPrefix(strings.TrimPrefix(field, "-"), "+")
+			c := compareValues(sortValue(hits[i], name), sortValue(hits[j], name))
+			if c == 0 {
+				continue
+			}
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return hits[i].ID < hits[j].ID
+	})
+}
+
+func sortValue(hit Hit, field string) interface{} {
+	switch field {
+	case "_score":
+		return hit.Score
+	case "_id":
+		return hit.ID
+	}
+	return hit.Source[field]
+}
+
+func compareValues(a, b interface{}) int {
+	fa, aok := toFloat(a)
+	fb, bok := toFloat(b)
+	if aok && bok {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// 按standard分词器的规则切词
+func tokenize(text string) []string {
+	var tokens []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+// 字段的文本，_all为全部字段拼接
+func fieldText(source map[string]interface{}, field string) string {
+	if field != "_all" {
+		if v, ok := source[field]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+		return ""
+	}
+	keys := make([]string, 0, len(source))
+	for k := range source {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		if source[k] != nil {
+			parts = append(parts, fmt.Sprint(source[k]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// 拼音字段的ngram切词
+func ngrams(text string) []string {
+	var tokens []string
+	for _, word := range tokenize(text) {
+		runes := []rune(word)
+		for i := range runes {
+			for j := i + pinyinMinGram; j <= len(runes) && j-i <= pinyinMaxGram; j++ {
+				tokens = append(tokens, string(runes[i:j]))
+			}
+		}
+	}
+	return tokens
+}
+
+type evaluator struct {
+	index    *memoryIndex
+	mappings *Mappings
+	// 当前文档的id，term查询_id时使用
+	id string
+}
+
+func (e *evaluator) value(source map[string]interface{}, field string) interface{} {
+	if field == "_id" {
+		return e.id
+	}
+	return source[field]
+}
+
+// 按字段的分词器切词，search为true时用search_analyzer
+func analyze(mappings *Mappings, field, text string, search bool) []string {
+	setting := mappings.Properties[field]
+	analyzer := setting.Analyzer
+	if search && setting.SearchAnalyzer != "" {
+		analyzer = setting.SearchAnalyzer
+	}
+	switch analyzer {
+	case PinyinAnalyzer:
+		return ngrams(text)
+	case PinyinSearchAnalyzer, "whitespace":
+		return strings.Fields(strings.ToLower(text))
+	}
+	return tokenize(text)
+}
+
+func (e *evaluator) analyze(field, text string) []string {
+	return analyze(e.mappings, field, text, true)
+}
+
+// 词频乘以idf累加，近似bm25的排序效果
+func (e *evaluator) score(query []string, field string) float64 {
+	tokens := e.index.tokens[e.id][field]
+	freq := make(map[string]int, len(tokens))
+	for _, t := range tokens {
+		freq[t]++
+	}
+	total := len(e.index.docs)
+	score := 0.0
+	for _, q := range query {
+		if freq[q] == 0 {
+			continue
+		}
+		df := e.index.df[field][q]
+		idf := math.Log(1 + (float64(total)-float64(df)+0.5)/(float64(df)+0.5))
+		score += float64(freq[q]) * idf / (float64(freq[q]) + 1.2)
+	}
+	return score
+}
+
+func containsPhrase(tokens, phrase []string) bool {
+	if len(phrase) == 0 {
+		return true
+	}
+	for i := 0; i+len(phrase) <= len(tokens); i++ {
+		hit := true
+		for j := range phrase {
+			if tokens[i+j] != phrase[j] {
+				hit = false
+				break
+			}
+		}
+		if hit {
+			return true
+		}
+	}
+	return false
+}
+
+// 取出{field: {"query": x}} 或 {field: x} 形式的字段和值
+func fieldClause(body interface{}, key string) (string, interface{}, error) {
+	item, ok := body.(map[string]interface{})
+	if !ok || len(item) != 1 {
+		return "", nil, fmt.Errorf("invalid clause %v", body)
+	}
+	for field, v := range item {
+		if inner, ok := v.(map[string]interface{}); ok {
+			return field, inner[key], nil
+		}
+		return field, v, nil
+	}
+	return "", nil, nil
+}
+
+func clauses(v interface{}) []map[string]interface{} {
+	var result []map[string]interface{}
+	switch items := v.(type) {
+	case []interface{}:
+		for _, item := range items {
+			if q, ok := item.(map[string]interface{}); ok {
+				result = append(result, q)
+			}
+		}
+	case map[string]interface{}:
+		result = append(result, items)
+	}
+	return result
+}
+
+func (e *evaluator) match(query map[string]interface{}, source map[string]interface{}) (bool, float64, error) {
+	for typ, body := range query {
+		switch typ {
+		case "match_all":
+			return true, 1, nil
+		case "match":
+			field, text, err := fieldClause(body, "query")
+			if err != nil {
+				return false, 0, err
+			}
+			score := e.score(e.analyze(field, fmt.Sprint(text)), field)
+			return score > 0, score, nil
+		case "multi_match":
+			item, _ := body.(map[string]interface{})
+			text := fmt.Sprint(item["query"])
+			fields := []string{"_all"}
+			if list, ok := item["fields"].([]interface{}); ok && len(list) > 0 {
+				fields = fields[:0]
+				for _, f := range list {
+					fields = append(fields, fmt.Sprint(f))
+				}
+			}
+			best := 0.0
+			for _, f := range fields {
+				best = math.Max(best, e.score(e.analyze(f, text), f))
+			}
+			return best > 0, best, nil
+		case "match_phrase":
+			field, text, err := fieldClause(body, "query")
+			if err != nil {
+				return false, 0, err
+			}
+			phrase := e.analyze(field, fmt.Sprint(text))
+			if !containsPhrase(e.index.tokens[e.id][field], phrase) {
+				return false, 0, nil
+			}
+			return true, e.score(phrase, field), nil
+		case "term":
+			field, value, err := fieldClause(body, "value")
+			if err != nil {
+				return false, 0, err
+			}
+			return e.value(source, field) != nil && compareValues(e.value(source, field), value) == 0, 1, nil
+		case "terms":
+			item, _ := body.(map[string]interface{})
+			for field, v := range item {
+				values, _ := v.([]interface{})
+				for _, value := range values {
+					if e.value(source, field) != nil && compareValues(e.value(source, field), value) == 0 {
+						return true, 1, nil
+					}
+				}
+			}
+			return false, 0, nil
+		case "prefix":
+			field, value, err := fieldClause(body, "value")
+			if err != nil {
+				return false, 0, err
+			}
+			prefix := strings.ToLower(fmt.Sprint(value))
+			for _, t := range tokenize(fieldText(source, field)) {
+				if strings.HasPrefix(t, prefix) {
+					return true, 1, nil
+				}
+			}
+			return false, 0, nil
+		case "range":
+			item, _ := body.(map[string]interface{})
+			for field, v := range item {
+				ops, _ := v.(map[string]interface{})
+				value, ok := toFloat(source[field])
+				if !ok {
+					return false, 0, nil
+				}
+				for op, bound := range ops {
+					b, ok := toFloat(bound)
+					if !ok {
+						return false, 0, fmt.Errorf("invalid range bound %v", bound)
+					}
+					if (op == "gt" && !(value > b)) || (op == "gte" && !(value >= b)) ||
+						(op == "lt" && !(value < b)) || (op == "lte" && !(value <= b)) {
+						return false, 0, nil
+					}
+				}
+			}
+			return true, 1, nil
+		case "bool":
+			item, _ := body.(map[string]interface{})
+			score := 0.0
+			for _, q := range clauses(item["must"]) {
+				ok, s, err := e.match(q, source)
+				if err != nil || !ok {
+					return false, 0, err
+				}
+				score += s
+			}
+			for _, q := range clauses(item["filter"]) {
+				ok, _, err := e.match(q, source)
+				if err != nil || !ok {
+					return false, 0, err
+				}
+			}
+			for _, q := range clauses(item["must_not"]) {
+				ok, _, err := e.match(q, source)
+				if err != nil {
+					return false, 0, err
+				}
+				if ok {
+					return false, 0, nil
+				}
+			}
+			should := clauses(item["should"])
+			matched := 0
+			for _, q := range should {
+				ok, s, err := e.match(q, source)
+				if err != nil {
+					return false, 0, err
+				}
+				if ok {
+					matched++
+					score += s
+				}
+			}
+			// 只有should时至少命中一个
+			if len(should) > 0 && matched == 0 && item["must"] == nil && item["filter"] == nil {
+				return false, 0, nil
+			}
+			if score == 0 {
+				score = 1
+			}
+			return true, score, nil
+		default:
+			return false, 0, fmt.Errorf("unsupported query %s", typ)
+		}
+	}
+	return true, 1, nil
+}
@@ -0,0 +1,77 @@
+package zincsearch
+
+import (
+	"context"
+	"testing"
+)
+
+func queryIDs(t *testing.T, m *Memory, index string, q Query) []string {
+	rsp, err := m.Query(context.Background(), index, &QueryRequest{Query: q, Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, hit := range rsp.Hits.Hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
+
+func TestMemoryDocumentFrequency(t *testing.T) {
+	m := NewMemory()
+	m.UpdateDocument("idx", "1", Document{Title: "alpha beta"})
+	m.UpdateDocument("idx", "2", Document{Title: "alpha gamma"})
+	m.UpdateDocument("idx", "3", Document{Title: "delta"})
+	df := m.indexes["idx"].df["title"]
+	if df["alpha"] != 2 || df["beta"] != 1 {
+		t.Fatalf("df %v", df)
+	}
+	// beta只在一个文档里出现，idf更高，同时命中alpha和beta的排第一
+	if ids := queryIDs(t, m, "idx", Match("title", "alpha beta")); len(ids) != 2 || ids[0] != "1" {
+		t.Fatalf("ids %v", ids)
+	}
+
+	m.UpdateDocument("idx", "1", Document{Title: "delta"})
+	m.DeleteDocument("idx", "2")
+	if _, ok := df["alpha"]; ok {
+		t.Fatalf("alpha should be removed: %v", df)
+	}
+	if df["delta"] != 2 {
+		t.Fatalf("df %v", df)
+	}
+	if ids := queryIDs(t, m, "idx", Match("title", "alpha")); len(ids) != 0 {
+		t.Fatalf("ids %v", ids)
+	}
+}
+
+func TestMemorySetMappingReanalyzes(t *testing.T) {
+	m := NewMemory()
+	m.UpdateDocument("idx", "1", Document{TitlePinyin: "lily"})
+	if ids := queryIDs(t, m, "idx", Match("title_pinyin", "li")); len(ids) != 1 {
+		t.Fatalf("ngram should match: %v", ids)
+	}
+	m.SetMapping("idx", &Mappings{Properties: map[string]FieldSetting{"title_pinyin": {Type: "text"}}})
+	if ids := queryIDs(t, m, "idx", Match("title_pinyin", "li")); len(ids) != 0 {
+		t.Fatalf("standard analyzer should not match prefix: %v", ids)
+	}
+	if ids := queryIDs(t, m, "idx", Match("title_pinyin", "lily")); len(ids) != 1 {
+		t.Fatalf("ids %v", ids)
+	}
+}
+
+func TestMemoryPhraseAndBool(t *testing.T) {
+	m := NewMemory()
+	m.SetAlias("search", "idx")
+	m.UpdateDocument("search", "1", Document{Title: "天河 小美", JsType: "qm"})
+	m.UpdateDocument("search", "2", Document{Title: "小美 天河", JsType: "hs"})
+	if ids := queryIDs(t, m, "search", MatchPhrase("title", "天河小美")); len(ids) != 1 || ids[0] != "1" {
+		t.Fatalf("phrase ids %v", ids)
+	}
+	q := (&BoolQuery{
+		Must:    []Query{Match("title", "小美")},
+		MustNot: []Query{Term("js_type", "qm")},
+	}).Query()
+	if ids := queryIDs(t, m, "search", q); len(ids) != 1 || ids[0] != "2" {
+		t.Fatalf("bool ids %v", ids)
+	}
+}
@@ -0,0 +1,204 @@
+package zincsearch
+
+import (
+	"fmt"
+	"time"
+)
+
+// 重建索引时每批拷贝的文档数
+const reindexBatchSize = 500
+
+type bulkRequest struct {
+	Index   string                   `json:"index"`
+	Records []map[string]interface{} `json:"records"`
+}
+
+// 批量写入文档，record里的_id作为文档id
+func (c *Client) BulkInsert(indexName string, records []map[string]interface{}) error {
+	if err := c.bulkInsert(indexName, records); err != nil {
+		return err
+	}
+	c.mirrorBulk(indexName, records)
+	return nil
+}
+
+func (c *Client) bulkInsert(indexName string, records []map[string]interface{}) error {
+	if len(records) == 0 {
+		return nil
+	}
+	url := fmt.Sprintf("%s/api/_bulkv2", c.baseURL)
+	return c.doRequest("POST", url, &bulkRequest{Index: indexName, Records: records}, nil)
+}
+
+// 分批遍历索引的全部文档
+func (c *Client) Scan(indexName string, batchSize int, fn func(hits []Hit) error) error {
+	from := 0
+	for {
+		req := &SearchRequest{
+			SearchType: "matchall",
+			From:       from,
+			MaxResults: batchSize,
+			SortFields: []string{"_id"},
+		}
+		rsp, err := c.Search(indexName, req)
+		if err != nil {
+			return err
+		}
+		if len(rsp.Hits.Hits) == 0 {
+			return nil
+		}
+		if err := fn(rsp.Hits.Hits); err != nil {
+			return err
+		}
+		from += len(rsp.Hits.Hits)
+		if len(rsp.Hits.Hits) < batchSize || from >= rsp.Hits.Total.Value {
+			return nil
+		}
+	}
+}
+
+// 把src的文档拷贝到新建的dst索引，返回拷贝的文档数
+func (c *Client) Reindex(src, dst string, schema *IndexSettings) (int, error) {
+	if err := c.createCopy(src, dst, schema); err != nil {
+		return 0, err
+	}
+	return c.copyDocuments(src, dst)
+}
+
+// 按schema新建dst索引，schema里没有settings时沿用src的settings
+func (c *Client) createCopy(src, dst string, schema *IndexSettings) error {
+	settings := &IndexSettings{
+		Name:        dst,
+		Storagetype: "disk",
+		Mappings:    schema.Mappings,
+		Settings:    schema.Settings,
+	}
+	if live, err := c.GetSettings(src); err == nil {
+		settings.NumberOfShards = live.NumberOfShards
+		if settings.Settings == nil {
+			settings.Settings = live
+		}
+	}
+	return c.CreateIndex(settings)
+}
+
+// 把src的全部文档按原id写入dst
+func (c *Client) copyDocuments(src, dst string) (int, error) {
+	count := 0
+	err := c.Scan(src, reindexBatchSize, func(hits []Hit) error {
+		records := make([]map[string]interface{}, 0, len(hits))
+		for _, hit := range hits {
+			record := recordFromHit(hit)
+			// 加拼音字段之前写入的文档没有拼音，拷贝时补上
+			fillPinyinSource(record)
+			record["_id"] = hit.ID
+			records = append(records, record)
+		}
+		if err := c.BulkInsert(dst, records); err != nil {
+			return err
+		}
+		count += len(records)
+		return nil
+	})
+	return count, err
+}
+
+// 拷贝命中文档的_source，@timestamp等内部字段由zincsearch重新生成
+func recordFromHit(hit Hit) map[string]interface{} {
+	record := make(map[string]interface{}, len(hit.Source)+1)
+	for k, v := range hit.Source {
+		if k == "@timestamp" || k == "_id" {
+			continue
+		}
+		record[k] = v
+	}
+	return record
+}
+
+// 给缺少拼音字段或者拼音过期的文档补上拼音，按原id整体写回，返回更新的文档数
+func (c *Client) BackfillPinyin(indexName string) (int, error) {
+	count := 0
+	err := c.Scan(indexName, reindexBatchSize, func(hits []Hit) error {
+		var records []map[string]interface{}
+		for _, hit := range hits {
+			record := recordFromHit(hit)
+			if !fillPinyinSource(record) {
+				continue
+			}
+			record["_id"] = hit.ID
+			records = append(records, record)
+		}
+		if err := c.BulkInsert(indexName, records); err != nil {
+			return err
+		}
+		count += len(records)
+		return nil
+	})
+	return count, err
+}
+
+// 计算迁移方案，字段变更或者新增字段用到线上没有的自定义分词器时需要重建
+func (c *Client) PlanMigration(indexName string, schema *IndexSettings) (*MappingDiff, bool, error) {
+	live, err := c.GetMapping(indexName)
+	if err != nil {
+		return nil, false, err
+	}
+	diff := DiffMapping(live, schema.Mappings)
+	if diff.NeedReindex() {
+		return diff, true, nil
+	}
+	if len(diff.Added) == 0 || schema.Settings == nil {
+		return diff, false, nil
+	}
+	liveSettings, err := c.GetSettings(indexName)
+	if err != nil {
+		return diff, false, err
+	}
+	return diff, missingAnalyzer(diff.Added, liveSettings, schema.Settings), nil
+}
+
+func analyzerNames(settings *Settings) map[string]bool {
+	names := make(map[string]bool)
+	if settings == nil {
+		return names
+	}
+	if analyzers, ok := settings.Analysis["analyzer"].(map[string]interface{}); ok {
+		for name := range analyzers {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+func missingAnalyzer(added map[string]FieldSetting, live, want *Settings) bool {
+	custom := analyzerNames(want)
+	exists := analyzerNames(live)
+	for _, field := range added {
+		for _, name := range []string{field.Analyzer, field.SearchAnalyzer} {
+			if custom[name] && !exists[name] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 把线上索引迁移到期望的schema
+// 能直接追加字段时追加到线上索引；否则拷贝到新索引，返回新索引名，由调用方切换
+func (c *Client) Migrate(indexName string, schema *IndexSettings) (string, *MappingDiff, error) {
+	diff, reindex, err := c.PlanMigration(indexName, schema)
+	if err != nil {
+		return indexName, diff, err
+	}
+	if diff.IsEmpty() {
+		return indexName, diff, nil
+	}
+	if !reindex {
+		return indexName, diff, c.UpdateMapping(indexName, &Mappings{Properties: diff.Added})
+	}
+	newIndex := fmt.Sprintf("%s_%s", indexName, time.Now().Format("20060102150405"))
+	if _, err := c.Reindex(indexName, newIndex, schema); err != nil {
+		return indexName, diff, err
+	}
+	return newIndex, diff, nil
+}
@@ -0,0 +1,236 @@
+package zincsearch
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 搜索语法说明，bot的帮助文案使用
+const QuerySyntaxHelp = `搜索语法:
+关键词: 直接输入，支持中文、拼音和首字母，如 小美 / xiaomei / xm
+短语: 用引号包起来，如 "天河 小美"
+地区: 地区:天河 或 location:天河
+类型: type:qm 或 type:hs
+联系方式: contact:telegram / contact:yuni / contact:siliao
+人数: members>1000 members>=500 members<100
+条件之间用空格分隔，可以和关键词组合，如 天河 type:qm members>1000`
+
+// 过滤条件
+type Filter struct {
+	Field string
+	// = > >= < <=
+	Op    string
+	Value string
+}
+
+// 解析后的搜索语句
+type ParsedQuery struct {
+	Keywords []string
+	Phrases  []string
+	Filters  []Filter
+}
+
+// 过滤条件的别名对应的索引字段
+var filterFields = map[string]string{
+	"地区":       "location",
+	"位置":       "location",
+	"location": "location",
+	"loc":      "location",
+	"类型":       "js_type",
+	"type":     "js_type",
+	"联系":       "contact_type",
+	"联系方式":     "contact_type",
+	"contact":  "contact_type",
+	"人数":       "user_count",
+	"members":  "user_count",
+	"member":   "user_count",
+	// 索引字段名本身，String()输出的语句可以重新解析
+	"js_type":      "js_type",
+	"contact_type": "contact_type",
+	"user_count":   "user_count",
+}
+
+// 比较符，长的放前面
+var filterOps = []string{">=", "<=", ">", "<", ":", "：", "="}
+
+// 解析搜索语句，无法识别的条件当作关键词
+func ParseQuery(text string) *ParsedQuery {
+	p := &ParsedQuery{}
+	for _, token := range splitQuery(text) {
+		if token.quoted {
+			if token.text != "" {
+				p.Phrases = append(p.Phrases, token.text)
+			}
+			continue
+		}
+		if filter, ok := parseFilter(token.text); ok {
+			p.Filters = append(p.Filters, filter)
+			continue
+		}
+		p.Keywords = append(p.Keywords, token.text)
+	}
+	return p
+}
+
+type queryToken struct {
+	text   string
+	quoted bool
+}
+
+func isQuote(r rune) bool {
+	return r == '"' || r == '“' || r == '”' || r == '「' || r == '」'
+}
+
+// 按空白切分，引号内的内容作为一个整体
+func splitQuery(text string) []queryToken {
+	var tokens []queryToken
+	var current []rune
+	quoted := false
+	flush := func(isQuoted bool) {
+		value := strings.TrimSpace(string(current))
+		if value != "" || isQuoted {
+			tokens = append(tokens, queryToken{text: value, quoted: isQuoted})
+		}
+		current = current[:0]
+	}
+	for _, r := range text {
+		switch {
+		case isQuote(r):
+			if quoted {
+				flush(true)
+			} else {
+				flush(false)
+			}
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			flush(false)
+		default:
+			current = append(current, r)
+		}
+	}
+	// 引号没有闭合时按普通关键词处理，同样按空白切分
+	if quoted {
+		for _, field := range strings.Fields(string(current)) {
+			tokens = append(tokens, queryToken{text: field})
+		}
+		return tokens
+	}
+	flush(false)
+	return tokens
+}
+
+func parseFilter(token string) (Filter, bool) {
+	for _, op := range filterOps {
+		idx := strings.Index(token, op)
+		if idx <= 0 {
+			continue
+		}
+		field, ok := filterFields[strings.ToLower(token[:idx])]
+		value := strings.TrimSpace(token[idx+len(op):])
+		if !ok || value == "" {
+			return Filter{}, false
+		}
+		if op == ":" || op == "：" {
+			op = "="
+		}
+		if field == "user_count" {
+			if _, err := strconv.Atoi(value); err != nil {
+				return Filter{}, false
+			}
+			// members:1000 表示至少1000人
+			if op == "=" {
+				op = ">="
+			}
+		} else if op != "=" {
+			return Filter{}, false
+		}
+		return Filter{Field: field, Op: op, Value: value}, true
+	}
+	return Filter{}, false
+}
+
+// 是否有关键词或者短语，只有过滤条件时按人数排序
+func (p *ParsedQuery) HasText() bool {
+	return len(p.Keywords) > 0 || len(p.Phrases) > 0
+}
+
+func (p *ParsedQuery) IsEmpty() bool {
+	return !p.HasText() && len(p.Filters) == 0
+}
+
+// 规范化的搜索语句，相同含义的输入得到相同的结果
+func (p *ParsedQuery) String() string {
+	var parts []string
+	for _, k := range p.Keywords {
+		parts = append(parts, strings.ToLower(k))
+	}
+	for _, phrase := range p.Phrases {
+		parts = append(parts, "\""+strings.ToLower(phrase)+"\"")
+	}
+	for _, f := range p.Filters {
+		op := f.Op
+		if op == "=" {
+			op = ":"
+		}
+		parts = append(parts, f.Field+op+strings.ToLower(f.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// 转换成zincsearch查询
+func (p *ParsedQuery) Query() Query {
+	return p.QueryWithSynonyms(nil)
+}
+
+// 转换成zincsearch查询，关键词和地区按同义词扩展，任意一种说法命中即可
+func (p *ParsedQuery) QueryWithSynonyms(synonyms *Synonyms) Query {
+	b := &BoolQuery{}
+	if len(p.Keywords) > 0 {
+		variants := synonyms.variants(p.Keywords)
+		if len(variants) == 1 {
+			b.Must = append(b.Must, KeywordQuery(variants[0]))
+		} else {
+			inner := &BoolQuery{}
+			for _, v := range variants {
+				inner.Should = append(inner.Should, KeywordQuery(v))
+			}
+			b.Must = append(b.Must, inner.Query())
+		}
+	}
+	for _, phrase := range p.Phrases {
+		inner := &BoolQuery{}
+		for _, field := range []string{"title", "js_name", "description", "location", "tags"} {
+			inner.Should = append(inner.Should, MatchPhrase(field, phrase))
+		}
+		b.Must = append(b.Must, inner.Query())
+	}
+	if len(b.Must) == 0 {
+		b.Must = append(b.Must, MatchAll())
+	}
+	for _, f := range p.Filters {
+		synonyms := synonyms.Expand(f.Value)
+		if f.Field != "location" || len(synonyms) == 0 {
+			b.Filter = append(b.Filter, f.Query())
+			continue
+		}
+		inner := &BoolQuery{Should: []Query{f.Query()}}
+		for _, v := range synonyms {
+			inner.Should = append(inner.Should, Match(f.Field, v))
+		}
+		b.Filter = append(b.Filter, inner.Query())
+	}
+	return b.Query()
+}
+
+func (f Filter) Query() Query {
+	switch f.Field {
+	case "user_count":
+		value, _ := strconv.Atoi(f.Value)
+		ops := map[string]string{">": "gt", ">=": "gte", "<": "lt", "<=": "lte"}
+		return Range(f.Field, map[string]interface{}{ops[f.Op]: value})
+	case "location":
+		return Match(f.Field, f.Value)
+	}
+	return Term(f.Field, strings.ToLower(f.Value))
+}
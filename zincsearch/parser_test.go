@@ -0,0 +1,115 @@
+package zincsearch
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	cases := []struct {
+		text string
+		want ParsedQuery
+	}{
+		{"小美 天河", ParsedQuery{Keywords: []string{"小美", "天河"}}},
+		{"  小美\t天河  ", ParsedQuery{Keywords: []string{"小美", "天河"}}},
+		{`"天河 小美" qm`, ParsedQuery{Keywords: []string{"qm"}, Phrases: []string{"天河 小美"}}},
+		{"“天河 小美”", ParsedQuery{Phrases: []string{"天河 小美"}}},
+		{"「天河」", ParsedQuery{Phrases: []string{"天河"}}},
+		// 引号没有闭合时按关键词处理，空引号忽略
+		{`"天河 小美`, ParsedQuery{Keywords: []string{"天河", "小美"}}},
+		{`"" 小美`, ParsedQuery{Keywords: []string{"小美"}}},
+		{"地区:天河", ParsedQuery{Filters: []Filter{{Field: "location", Op: "=", Value: "天河"}}}},
+		{"地区：天河", ParsedQuery{Filters: []Filter{{Field: "location", Op: "=", Value: "天河"}}}},
+		{"LOC=天河", ParsedQuery{Filters: []Filter{{Field: "location", Op: "=", Value: "天河"}}}},
+		{"type:qm", ParsedQuery{Filters: []Filter{{Field: "js_type", Op: "=", Value: "qm"}}}},
+		{"联系方式:yuni", ParsedQuery{Filters: []Filter{{Field: "contact_type", Op: "=", Value: "yuni"}}}},
+		{"contact:telegram", ParsedQuery{Filters: []Filter{{Field: "contact_type", Op: "=", Value: "telegram"}}}},
+		{"members>1000", ParsedQuery{Filters: []Filter{{Field: "user_count", Op: ">", Value: "1000"}}}},
+		{"members>=500", ParsedQuery{Filters: []Filter{{Field: "user_count", Op: ">=", Value: "500"}}}},
+		{"人数<100", ParsedQuery{Filters: []Filter{{Field: "user_count", Op: "<", Value: "100"}}}},
+		{"members<=100", ParsedQuery{Filters: []Filter{{Field: "user_count", Op: "<=", Value: "100"}}}},
+		// members:1000 表示至少1000人
+		{"members:1000", ParsedQuery{Filters: []Filter{{Field: "user_count", Op: ">=", Value: "1000"}}}},
+		// 无法识别的条件当作关键词
+		{"members>many", ParsedQuery{Keywords: []string{"members>many"}}},
+		{"type>qm", ParsedQuery{Keywords: []string{"type>qm"}}},
+		{"地区:", ParsedQuery{Keywords: []string{"地区:"}}},
+		{":天河", ParsedQuery{Keywords: []string{":天河"}}},
+		{"http://t.me", ParsedQuery{Keywords: []string{"http://t.me"}}},
+		{"天河 type:qm members>1000", ParsedQuery{
+			Keywords: []string{"天河"},
+			Filters:  []Filter{{Field: "js_type", Op: "=", Value: "qm"}, {Field: "user_count", Op: ">", Value: "1000"}},
+		}},
+	}
+	for _, tc := range cases {
+		if got := ParseQuery(tc.text); !reflect.DeepEqual(*got, tc.want) {
+			t.Errorf("%q: got %+v, want %+v", tc.text, *got, tc.want)
+		}
+	}
+}
+
+func TestParsedQueryString(t *testing.T) {
+	cases := []struct {
+		text string
+		want string
+	}{
+		{"小美 天河", "小美 天河"},
+		{"  Lily   GZ ", "lily gz"},
+		{`qm "天河 小美"`, `qm "天河 小美"`},
+		{`"天河 小美" qm`, `qm "天河 小美"`},
+		{"地区：天河 type:QM", "location:天河 js_type:qm"},
+		{"members:1000", "user_count>=1000"},
+		{"人数>1000 天河", "天河 user_count>1000"},
+		{"", ""},
+	}
+	for _, tc := range cases {
+		got := ParseQuery(tc.text).String()
+		if got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.text, got, tc.want)
+		}
+		// 规范化的语句重新解析后不变，缓存、订阅和统计都按它做key
+		if again := ParseQuery(got).String(); again != got {
+			t.Errorf("%q: round trip %q => %q", tc.text, got, again)
+		}
+	}
+}
+
+func TestFilterQuery(t *testing.T) {
+	cases := []struct {
+		text string
+		want Query
+	}{
+		{"members>1000", Range("user_count", map[string]interface{}{"gt": 1000})},
+		{"members>=500", Range("user_count", map[string]interface{}{"gte": 500})},
+		{"members<100", Range("user_count", map[string]interface{}{"lt": 100})},
+		{"members<=100", Range("user_count", map[string]interface{}{"lte": 100})},
+		{"地区:天河", Match("location", "天河")},
+		{"type:QM", Term("js_type", "qm")},
+		{"contact:Yuni", Term("contact_type", "yuni")},
+	}
+	for _, tc := range cases {
+		parsed := ParseQuery(tc.text)
+		if len(parsed.Filters) != 1 {
+			t.Fatalf("%q: filters %+v", tc.text, parsed.Filters)
+		}
+		if got := parsed.Filters[0].Query(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %v, want %v", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestParsedQueryFilters(t *testing.T) {
+	query := ParseQuery("type:qm").Query()
+	want := (&BoolQuery{Must: []Query{MatchAll()}, Filter: []Query{Term("js_type", "qm")}}).Query()
+	if !reflect.DeepEqual(query, want) {
+		t.Fatalf("got %v, want %v", query, want)
+	}
+	// 地区按同义词扩展，任意一种说法命中即可
+	synonyms := NewSynonyms([][]string{{"天河", "天河区"}})
+	query = ParseQuery("地区:天河").QueryWithSynonyms(synonyms)
+	location := (&BoolQuery{Should: []Query{Match("location", "天河"), Match("location", "天河区")}}).Query()
+	want = (&BoolQuery{Must: []Query{MatchAll()}, Filter: []Query{location}}).Query()
+	if !reflect.DeepEqual(query, want) {
+		t.Fatalf("got %v, want %v", query, want)
+	}
+}
@@ -0,0 +1,96 @@
+package zincsearch
+
+import (
+	"context"
+	"fmt"
+)
+
+// es风格的查询语句，通过/es/{index}/_search执行，支持bool组合
+type Query map[string]interface{}
+
+// es风格的搜索请求
+type QueryRequest struct {
+	Query Query `json:"query"`
+	From  int   `json:"from"`
+	Size  int   `json:"size"`
+	// 排序字段，"-"开头表示降序，如 -_score -user_count
+	Sort   []string `json:"sort,omitempty"`
+	Source []string `json:"_source,omitempty"`
+}
+
+func MatchAll() Query {
+	return Query{"match_all": map[string]interface{}{}}
+}
+
+// 分词匹配单个字段
+func Match(field, text string) Query {
+	return Query{"match": map[string]interface{}{
+		field: map[string]interface{}{"query": text},
+	}}
+}
+
+// 分词匹配多个字段，fields为空时匹配全部字段
+func MultiMatch(text string, fields ...string) Query {
+	item := map[string]interface{}{"query": text}
+	if len(fields) > 0 {
+		item["fields"] = fields
+	}
+	return Query{"multi_match": item}
+}
+
+// 短语匹配，词需要按顺序相邻出现
+func MatchPhrase(field, text string) Query {
+	return Query{"match_phrase": map[string]interface{}{
+		field: map[string]interface{}{"query": text},
+	}}
+}
+
+// 精确匹配，适用于keyword字段
+func Term(field string, value interface{}) Query {
+	return Query{"term": map[string]interface{}{
+		field: map[string]interface{}{"value": value},
+	}}
+}
+
+// 精确匹配任意一个值
+func Terms(field string, values ...interface{}) Query {
+	return Query{"terms": map[string]interface{}{field: values}}
+}
+
+// 范围查询，ops的key为gt gte lt lte
+func Range(field string, ops map[string]interface{}) Query {
+	return Query{"range": map[string]interface{}{field: ops}}
+}
+
+// bool组合查询
+type BoolQuery struct {
+	Must    []Query
+	Filter  []Query
+	Should  []Query
+	MustNot []Query
+}
+
+func (b *BoolQuery) Query() Query {
+	item := map[string]interface{}{}
+	if len(b.Must) > 0 {
+		item["must"] = b.Must
+	}
+	if len(b.Filter) > 0 {
+		item["filter"] = b.Filter
+	}
+	if len(b.Should) > 0 {
+		item["should"] = b.Should
+	}
+	if len(b.MustNot) > 0 {
+		item["must_not"] = b.MustNot
+	}
+	return Query{"bool": item}
+}
+
+// 执行es风格的查询
+func (c *Client) Query(ctx context.Context, indexName string, req *QueryRequest) (*SearchResponse, error) {
+	url := fmt.Sprintf("%s/es/%s/_search", c.baseURL, indexName)
+	var response SearchResponse
+	err := c.doRequestContext(ctx, "POST", url, req, &response)
+	return &response, err
+}
@@ -0,0 +1,95 @@
+package zincsearch
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// 排序各项的权重，最终分数 = 各项归一化后的值 * 权重之和
+type RankWeights struct {
+	// zincsearch的相关性分数，按本批结果的最高分归一化
+	Score float64
+	// log(1+人数)，按本批结果的最大值归一化
+	Members float64
+	// 新鲜度，按created_at指数衰减，FreshnessHalfLife后衰减一半，没有created_at的文档不加分
+	Freshness         float64
+	FreshnessHalfLife time.Duration
+	// 管理员设置的加权，原值直接相加，负数可以降权
+	Boost float64
+}
+
+func DefaultRankWeights() RankWeights {
+	return RankWeights{
+		Score:             1,
+		Members:           0.6,
+		Freshness:         0.2,
+		FreshnessHalfLife: 30 * 24 * time.Hour,
+		Boost:             1,
+	}
+}
+
+// 排序分数的组成，管理员查看排序原因用
+type RankExplain struct {
+	Score     float64
+	Members   float64
+	Freshness float64
+	Boost     float64
+	Total     float64
+}
+
+func (e RankExplain) String() string {
+	return fmt.Sprintf("total=%.3f score=%.3f members=%.3f fresh=%.3f boost=%.3f",
+		e.Total, e.Score, e.Members, e.Freshness, e.Boost)
+}
+
+type RankedHit struct {
+	Hit
+	Explain RankExplain
+}
+
+// 文档第一次收录的时间，@timestamp每次写入都会更新，不能用来算新鲜度
+func hitCreatedAt(hit Hit) (time.Time, bool) {
+	value, ok := hit.Source["created_at"].(float64)
+	if !ok || value <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(value), 0), true
+}
+
+// 对一批命中结果重新排序，boosts的key是文档id
+// 各项归一化后的值也乘上权重放在Explain里，Total相同时保持原顺序
+func Rank(hits []Hit, w RankWeights, boosts map[string]float64, now time.Time) []RankedHit {
+	maxScore, maxMembers := 0.0, 0.0
+	for _, hit := range hits {
+		maxScore = math.Max(maxScore, hit.Score)
+		count, _ := hit.Source["user_count"].(float64)
+		maxMembers = math.Max(maxMembers, math.Log1p(math.Max(count, 0)))
+	}
+	ranked := make([]RankedHit, 0, len(hits))
+	for _, hit := range hits {
+		var e RankExplain
+		if maxScore > 0 {
+			e.Score = w.Score * hit.Score / maxScore
+		} else {
+			// 只有过滤条件时分数都一样
+			e.Score = w.Score
+		}
+		if maxMembers > 0 {
+			count, _ := hit.Source["user_count"].(float64)
+			e.Members = w.Members * math.Log1p(math.Max(count, 0)) / maxMembers
+		}
+		if t, ok := hitCreatedAt(hit); ok && w.FreshnessHalfLife > 0 {
+			age := math.Max(now.Sub(t).Hours(), 0)
+			e.Freshness = w.Freshness * math.Pow(0.5, age/w.FreshnessHalfLife.Hours())
+		}
+		e.Boost = w.Boost * boosts[hit.ID]
+		e.Total = e.Score + e.Members + e.Freshness + e.Boost
+		ranked = append(ranked, RankedHit{Hit: hit, Explain: e})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Explain.Total > ranked[j].Explain.Total
+	})
+	return ranked
+}
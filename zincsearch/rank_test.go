@@ -0,0 +1,51 @@
+package zincsearch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRankFreshnessUsesCreatedAt(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	w := DefaultRankWeights()
+	hits := []Hit{
+		// 很早收录，最近刷新过，@timestamp是新的
+		{ID: "old", Score: 1, Source: map[string]interface{}{
+			"created_at": float64(now.Add(-365 * 24 * time.Hour).Unix()),
+			"@timestamp": now.Add(-time.Minute).Format(time.RFC3339Nano),
+		}},
+		{ID: "new", Score: 1, Source: map[string]interface{}{
+			"created_at": float64(now.Add(-time.Hour).Unix()),
+			"@timestamp": now.Add(-time.Hour).Format(time.RFC3339Nano),
+		}},
+		{ID: "legacy", Score: 1, Source: map[string]interface{}{
+			"@timestamp": now.Format(time.RFC3339Nano),
+		}},
+	}
+	ranked := Rank(hits, w, nil, now)
+	fresh := make(map[string]float64)
+	for _, hit := range ranked {
+		fresh[hit.ID] = hit.Explain.Freshness
+	}
+	if fresh["old"] > w.Freshness*0.01 {
+		t.Errorf("old document got freshness %f", fresh["old"])
+	}
+	if fresh["new"] < w.Freshness*0.99 {
+		t.Errorf("new document got freshness %f", fresh["new"])
+	}
+	if fresh["legacy"] != 0 {
+		t.Errorf("document without created_at got freshness %f", fresh["legacy"])
+	}
+	if ranked[0].ID != "new" {
+		t.Fatalf("order %s %s %s", ranked[0].ID, ranked[1].ID, ranked[2].ID)
+	}
+}
+
+func TestRankHalfLife(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	w := RankWeights{Freshness: 1, FreshnessHalfLife: 24 * time.Hour}
+	hits := []Hit{{ID: "a", Source: map[string]interface{}{"created_at": float64(now.Add(-24 * time.Hour).Unix())}}}
+	if got := Rank(hits, w, nil, now)[0].Explain.Freshness; got < 0.499 || got > 0.501 {
+		t.Fatalf("freshness after one half life %f", got)
+	}
+}
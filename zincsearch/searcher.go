@@ -0,0 +1,54 @@
+package zincsearch
+
+import (
+	"context"
+)
+
+// 搜索接口，Client和Memory都实现，bot里依赖接口以便脱离zincsearch测试
+type Searcher interface {
+	Search(indexName string, req *SearchRequest) (*SearchResponse, error)
+	SearchContext(ctx context.Context, indexName string, req *SearchRequest) (*SearchResponse, error)
+	Query(ctx context.Context, indexName string, req *QueryRequest) (*SearchResponse, error)
+}
+
+// 写入接口
+type Indexer interface {
+	InsertDocument(indexName string, document interface{}) error
+	UpdateDocument(indexName, docID string, document interface{}) error
+	DeleteDocument(indexName, docID string) error
+	// 部分更新，只改fields里的字段
+	UpdateFields(indexName, docID string, fields map[string]interface{}) error
+	// 别名解析成实际索引，写入前调用
+	ResolveIndex(name string) (string, error)
+}
+
+var (
+	_ Searcher = (*Client)(nil)
+	_ Indexer  = (*Client)(nil)
+	_ Searcher = (*Memory)(nil)
+	_ Indexer  = (*Memory)(nil)
+)
+
+// 把命中结果转换成Document
+func DocumentFromHit(hit Hit) Document {
+	doc := Document{ID: hit.ID}
+	doc.Title, _ = hit.Source["title"].(string)
+	doc.Description, _ = hit.Source["description"].(string)
+	doc.ChatID, _ = hit.Source["chat_id"].(string)
+	if count, ok := hit.Source["user_count"].(float64); ok {
+		doc.UserCount = int(count)
+	}
+	doc.JsName, _ = hit.Source["js_name"].(string)
+	doc.JsType, _ = hit.Source["js_type"].(string)
+	doc.Location, _ = hit.Source["location"].(string)
+	doc.Tags, _ = hit.Source["tags"].(string)
+	doc.ContactType, _ = hit.Source["contact_type"].(string)
+	if ts, ok := hit.Source["created_at"].(float64); ok {
+		doc.CreatedAt = int64(ts)
+	}
+	if ts, ok := hit.Source["refreshed_at"].(float64); ok {
+		doc.RefreshedAt = int64(ts)
+	}
+	doc.Hidden, _ = hit.Source["hidden"].(bool)
+	return doc
+}
@@ -0,0 +1,244 @@
+package zincsearch
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// 搜索词纠错用的词典，词来自索引里的title js_name location tags
+type Dictionary struct {
+	// 词 => 出现次数
+	terms map[string]int
+	// 词 => 全拼，没有汉字的词为空
+	pinyin map[string]string
+}
+
+func NewDictionary() *Dictionary {
+	return &Dictionary{
+		terms:  make(map[string]int),
+		pinyin: make(map[string]string),
+	}
+}
+
+const (
+	// 收录的词的长度范围，单字不收录
+	dictMinRunes = 2
+	dictMaxRunes = 20
+	// 中文标题一般不带空格，连续的汉字再切成2到4个字的片段收录，标题里的词才能被纠错到
+	dictMaxGram = 4
+)
+
+// 按非字母数字切词，汉字和其它字符分开，连续的汉字再切成n-gram
+// 单字和超长的词不收录
+func dictionaryTerms(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var terms []string
+	for _, field := range fields {
+		for _, run := range splitHan(field) {
+			runes := []rune(run)
+			if len(runes) >= dictMinRunes && len(runes) <= dictMaxRunes {
+				terms = append(terms, run)
+			}
+			if !unicode.Is(unicode.Han, runes[0]) {
+				continue
+			}
+			for n := dictMinRunes; n <= dictMaxGram && n < len(runes); n++ {
+				for i := 0; i+n <= len(runes); i++ {
+					terms = append(terms, string(runes[i:i+n]))
+				}
+			}
+		}
+	}
+	return terms
+}
+
+// 把连续的汉字和连续的其它字符分开
+func splitHan(text string) []string {
+	var runs []string
+	start, prev := 0, false
+	for i, r := range text {
+		han := unicode.Is(unicode.Han, r)
+		if i > 0 && han != prev {
+			runs = append(runs, text[start:i])
+			start = i
+		}
+		prev = han
+	}
+	if start < len(text) {
+		runs = append(runs, text[start:])
+	}
+	return runs
+}
+
+func (d *Dictionary) Add(text string) {
+	for _, term := range dictionaryTerms(text) {
+		if _, ok := d.terms[term]; !ok {
+			if full, _ := ToPinyin(term); full != term {
+				d.pinyin[term] = strings.ReplaceAll(full, " ", "")
+			}
+		}
+		d.terms[term]++
+	}
+}
+
+func (d *Dictionary) AddDocument(doc Document) {
+	d.Add(doc.Title)
+	d.Add(doc.JsName)
+	d.Add(doc.Location)
+	d.Add(doc.Tags)
+}
+
+func (d *Dictionary) Len() int {
+	return len(d.terms)
+}
+
+func (d *Dictionary) Contains(term string) bool {
+	_, ok := d.terms[strings.ToLower(term)]
+	return ok
+}
+
+// 编辑距离，超过limit后提前返回limit+1
+func editDistance(a, b []rune, limit int) int {
+	if abs(len(a)-len(b)) > limit {
+		return limit + 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if cur[j] < best {
+				best = cur[j]
+			}
+		}
+		if best > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// 允许的编辑距离，短词只允许错一个字
+func maxDistance(n int) int {
+	if n <= 4 {
+		return 1
+	}
+	return 2
+}
+
+type suggestion struct {
+	term     string
+	distance int
+	freq     int
+}
+
+// 给一个词找相近的词，按拼音相同、编辑距离、出现次数排序
+// 拼音相同的同音字距离记为0，输入拼音时也能对应到中文词
+func (d *Dictionary) Suggest(word string, n int) []string {
+	word = strings.ToLower(strings.TrimSpace(word))
+	runes := []rune(word)
+	if len(runes) == 0 || n <= 0 {
+		return nil
+	}
+	full, _ := ToPinyin(word)
+	full = strings.ReplaceAll(full, " ", "")
+	limit := maxDistance(len(runes))
+	pinyinLimit := maxDistance(len(full) / 3)
+	var candidates []suggestion
+	for term, freq := range d.terms {
+		if term == word {
+			continue
+		}
+		distance := editDistance(runes, []rune(term), limit)
+		if py := d.pinyin[term]; py != "" && full != "" {
+			if pd := editDistance([]rune(full), []rune(py), pinyinLimit); pd == 0 {
+				distance = 0
+			} else if pd <= pinyinLimit && pd < distance {
+				distance = pd
+			}
+		}
+		if distance > limit {
+			continue
+		}
+		candidates = append(candidates, suggestion{term: term, distance: distance, freq: freq})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		if candidates[i].freq != candidates[j].freq {
+			return candidates[i].freq > candidates[j].freq
+		}
+		return candidates[i].term < candidates[j].term
+	})
+	var result []string
+	for i := 0; i < len(candidates) && i < n; i++ {
+		result = append(result, candidates[i].term)
+	}
+	return result
+}
+
+// 给整个搜索语句生成纠错后的搜索语句，词典里没有的关键词换成相近的词
+// 第一个不认识的关键词取不同的候选词，生成最多n条
+func (d *Dictionary) SuggestQuery(text string, n int) []string {
+	parsed := ParseQuery(text)
+	unknown := -1
+	best := make([]string, len(parsed.Keywords))
+	var options []string
+	for i, keyword := range parsed.Keywords {
+		best[i] = keyword
+		if d.Contains(keyword) {
+			continue
+		}
+		candidates := d.Suggest(keyword, n)
+		if len(candidates) == 0 {
+			continue
+		}
+		best[i] = candidates[0]
+		if unknown == -1 {
+			unknown = i
+			options = candidates
+		}
+	}
+	if unknown == -1 {
+		return nil
+	}
+	var result []string
+	for _, option := range options {
+		q := *parsed
+		q.Keywords = append([]string(nil), best...)
+		q.Keywords[unknown] = option
+		result = append(result, q.String())
+	}
+	return result
+}
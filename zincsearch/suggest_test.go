@@ -0,0 +1,106 @@
+package zincsearch
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDictionaryTerms(t *testing.T) {
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"Lily GZ", []string{"lily", "gz"}},
+		{"a 天 qm", []string{"qm"}},
+		{"天河", []string{"天河"}},
+		{"天河区", []string{"天河区", "天河", "河区"}},
+		// 没有空格的中文标题切成片段
+		{"广州天河小美", []string{"广州天河小美",
+			"广州", "州天", "天河", "河小", "小美",
+			"广州天", "州天河", "天河小", "河小美",
+			"广州天河", "州天河小", "天河小美"}},
+		{"天河qm", []string{"天河", "qm"}},
+		{"天河-qm", []string{"天河", "qm"}},
+		{"abcdefghijklmnopqrstuvwxyz", nil},
+	}
+	for _, tc := range cases {
+		if got := dictionaryTerms(tc.text); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"", "", 2, 0},
+		{"天河", "天河", 2, 0},
+		{"天河", "天和", 2, 1},
+		{"telegram", "telegran", 2, 1},
+		{"telegram", "tele", 2, 3},
+		{"kitten", "sitting", 3, 3},
+		{"kitten", "sitting", 1, 2},
+		{"abc", "", 5, 3},
+	}
+	for _, tc := range cases {
+		if got := editDistance([]rune(tc.a), []rune(tc.b), tc.limit); got != tc.want {
+			t.Errorf("%q %q limit %d: got %d, want %d", tc.a, tc.b, tc.limit, got, tc.want)
+		}
+	}
+}
+
+func suggestDictionary() *Dictionary {
+	d := NewDictionary()
+	d.AddDocument(Document{Title: "广州天河小美", Location: "天河区", Tags: "qm telegram"})
+	d.AddDocument(Document{Title: "海珠小美"})
+	d.AddDocument(Document{Title: "天河 小美"})
+	return d
+}
+
+func TestSuggest(t *testing.T) {
+	d := suggestDictionary()
+	cases := []struct {
+		word string
+		want string
+	}{
+		// 标题里的词也能纠错到
+		{"天和", "天河"},
+		{"海猪", "海珠"},
+		{"tianhe", "天河"},
+		{"telegran", "telegram"},
+	}
+	for _, tc := range cases {
+		got := d.Suggest(tc.word, 3)
+		if len(got) == 0 || got[0] != tc.want {
+			t.Errorf("%q: got %q, want %q first", tc.word, got, tc.want)
+		}
+	}
+	if got := d.Suggest("", 3); got != nil {
+		t.Errorf("empty word: %q", got)
+	}
+	if got := d.Suggest("天和", 0); got != nil {
+		t.Errorf("n=0: %q", got)
+	}
+	if got := d.Suggest("zzzzzzzz", 3); got != nil {
+		t.Errorf("no match: %q", got)
+	}
+}
+
+func TestSuggestQuery(t *testing.T) {
+	d := suggestDictionary()
+	got := d.SuggestQuery("天和 小美 type:qm", 3)
+	if len(got) == 0 || got[0] != "天河 小美 js_type:qm" {
+		t.Fatalf("got %q", got)
+	}
+	// 关键词都认识时不纠错
+	if got := d.SuggestQuery("天河 小美", 3); got != nil {
+		t.Fatalf("known words: %q", got)
+	}
+	if got := d.SuggestQuery("type:qm", 3); got != nil {
+		t.Fatalf("filters only: %q", got)
+	}
+}
@@ -0,0 +1,64 @@
+package zincsearch
+
+import (
+	"strings"
+)
+
+// 一个关键词扩展出的搜索语句上限，避免同义词组合过多
+const maxSynonymVariants = 10
+
+// 同义词表，同一组里的词互为同义词，如 天河 天河区 th
+type Synonyms struct {
+	groups map[string][]string
+}
+
+// 词不区分大小写，一个词出现在多个组时以后面的组为准
+func NewSynonyms(groups [][]string) *Synonyms {
+	s := &Synonyms{groups: make(map[string][]string)}
+	for _, group := range groups {
+		var terms []string
+		for _, term := range group {
+			if term = strings.ToLower(strings.TrimSpace(term)); term != "" {
+				terms = append(terms, term)
+			}
+		}
+		if len(terms) < 2 {
+			continue
+		}
+		for _, term := range terms {
+			s.groups[term] = terms
+		}
+	}
+	return s
+}
+
+// 词的同义词，不包括词本身
+func (s *Synonyms) Expand(term string) []string {
+	if s == nil {
+		return nil
+	}
+	term = strings.ToLower(term)
+	var result []string
+	for _, v := range s.groups[term] {
+		if v != term {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// 关键词按同义词替换生成的搜索语句，第一个是原始语句
+func (s *Synonyms) variants(keywords []string) []string {
+	result := []string{strings.Join(keywords, " ")}
+	for i, keyword := range keywords {
+		for _, synonym := range s.Expand(keyword) {
+			if len(result) >= maxSynonymVariants {
+				return result
+			}
+			replaced := append([]string(nil), keywords...)
+			replaced[i] = synonym
+			result = append(result, strings.Join(replaced, " "))
+		}
+	}
+	return result
+}
@@ -2,33 +2,59 @@ package zincsearch
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"math/rand"
 	"zincsearch/lib"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
+// 未配置地址时使用的zincsearch，NewClient传空地址时读取，测试或者部署时可以改
+var DefaultBaseURL = "http://localhost:4080"
+
 type FieldSetting struct{
 	Type string `json:"type,omitempty"`
-	Index bool `json:"index",omitempty`
-	Sortable bool `json:"sortable:omitempty"`
-	Store bool `json:"store"`
+	Index bool `json:"index,omitempty"`
+	Store bool `json:"store,omitempty"`
+	Sortable bool `json:"sortable,omitempty"`
+	Aggregatable bool `json:"aggregatable,omitempty"`
+	Highlightable bool `json:"highlightable,omitempty"`
+	Analyzer string `json:"analyzer,omitempty"`
+	SearchAnalyzer string `json:"search_analyzer,omitempty"`
+	Format string `json:"format,omitempty"`
 }
 
+// zinc tag声明索引字段的mapping，格式: 类型,选项...，见MappingOf
 type Document struct {
-	Title string `json:"title"`
-	Description string `json:"description"`
-	ChatID string `json:"chat_id"`
-	UserCount int `json:"user_count"`
-	JsName string `json:"js_name"`
+	Title string `json:"title" zinc:"text,index,store,highlightable,analyzer=gse_standard,search_analyzer=gse_search"`
+	Description string `json:"description" zinc:"text,index,store,analyzer=gse_standard,search_analyzer=gse_search"`
+	ChatID string `json:"chat_id" zinc:"keyword,index,store"`
+	UserCount int `json:"user_count" zinc:"numeric,index,store,sortable,aggregatable"`
+	JsName string `json:"js_name" zinc:"text,index,store,highlightable,analyzer=gse_standard,search_analyzer=gse_search"`
 	// js_type: qm hs
-	JsType string `json:"js_type"`
-	Location string `json:"location"`
-	Tags string `json:"tags"`
+	JsType string `json:"js_type" zinc:"keyword,index,store,aggregatable"`
+	Location string `json:"location" zinc:"text,index,store,analyzer=gse_standard,search_analyzer=gse_search"`
+	Tags string `json:"tags" zinc:"text,index,store,analyzer=gse_standard,search_analyzer=gse_search"`
 	// ContactType default:telegram, others:wechat,yuni,qq
-	ContactType string `json:"contact_type"`
-	ID string `json:"id"`
+	ContactType string `json:"contact_type" zinc:"keyword,index,store,aggregatable"`
+	ID string `json:"id" zinc:"-"`
+	// 首次写入的时间，订阅推送按这个字段找新文档
+	CreatedAt int64 `json:"created_at,omitempty" zinc:"numeric,index,store,sortable"`
+	// 最后一次从telegram获取人数和标题的时间
+	RefreshedAt int64 `json:"refreshed_at,omitempty" zinc:"numeric,index,store,sortable"`
+	// 链接失效后从搜索结果隐藏，管理员确认后删除或者恢复
+	Hidden bool `json:"hidden,omitempty" zinc:"bool,index,store"`
+	// 拼音字段由FillPinyin生成，"gz"或者"guangzhou"都能搜到广州
+	TitlePinyin string `json:"title_pinyin,omitempty" zinc:"text,index,analyzer=pinyin_ngram,search_analyzer=pinyin_query"`
+	TitleInitials string `json:"title_initials,omitempty" zinc:"text,index,analyzer=pinyin_ngram,search_analyzer=pinyin_query"`
+	JsNamePinyin string `json:"js_name_pinyin,omitempty" zinc:"text,index,analyzer=pinyin_ngram,search_analyzer=pinyin_query"`
+	JsNameInitials string `json:"js_name_initials,omitempty" zinc:"text,index,analyzer=pinyin_ngram,search_analyzer=pinyin_query"`
 }
 
 type Client struct {
@@ -36,6 +62,38 @@ type Client struct {
 	username   string
 	password   string
 	httpClient *http.Client
+	config     ClientConfig
+	breaker    *breaker
+	// 重建索引期间写入旧索引的文档同时写到新索引，见ReindexAlias
+	mirrorMutex sync.Mutex
+	mirrors     map[string]*mirror
+}
+
+// 客户端配置，零值字段使用DefaultClientConfig里的默认值
+type ClientConfig struct {
+	// 单次请求超时，要比调用方context的超时短，否则卡住的请求只会按调用方超时处理，不计入熔断
+	Timeout time.Duration
+	// 5xx或者连接错误时的重试次数
+	MaxRetries int
+	// 重试的基础间隔，每次翻倍并加随机抖动
+	RetryBackoff time.Duration
+	// 连续失败多少次后熔断
+	BreakerThreshold int
+	// 熔断后多久放一个请求探测
+	BreakerCooldown time.Duration
+	// 连接池大小
+	MaxIdleConns int
+}
+
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		Timeout:          5 * time.Second,
+		MaxRetries:       2,
+		RetryBackoff:     100 * time.Millisecond,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+		MaxIdleConns:     64,
+	}
 }
 
 // 索引配置结构体
@@ -43,7 +101,8 @@ type IndexSettings struct {
 	Name             string                             `json:"name"`
 	NumberOfShards   int                    `json:"shard_num"`
 	Storagetype      string                      `json:"storage_type"`
-	Mappings         map[string]interface{} `json:"mappings"`
+	Mappings         *Mappings              `json:"mappings,omitempty"`
+	Settings         *Settings              `json:"settings,omitempty"`
 }
 
 type IndexSettingsList struct {
@@ -57,6 +116,15 @@ type SearchRequest struct {
 	From       int                    `json:"from"`
 	MaxResults int                    `json:"max_results"`
 	SortFields []string               `json:"sort_fields"`
+	// 返回的字段，为空时返回全部字段
+	Source     []string               `json:"_source,omitempty"`
+}
+
+// 搜索命中的文档
+type Hit struct {
+	ID     string                 `json:"_id"`
+	Score  float64                `json:"_score"`
+	Source map[string]interface{} `json:"_source"`
 }
 
 // 搜索响应结构体
@@ -65,10 +133,7 @@ type SearchResponse struct {
 		Total struct {
 			Value int `json:"value"`
 		} `json:"total"`
-		Hits []struct {
-			ID     string                 `json:"_id"`
-			Source map[string]interface{} `json:"_source"`
-		} `json:"hits"`
+		Hits []Hit `json:"hits"`
 	} `json:"hits"`
 }
 
@@ -77,12 +142,65 @@ type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
+// zincsearch返回的非2xx错误
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("zincsearch error (%d): %s", e.StatusCode, e.Message)
+}
+
 func NewClient(baseURL, username, password string) *Client {
+	return NewClientWithConfig(baseURL, username, password, DefaultClientConfig())
+}
+
+// 客户端内部复用连接，应当长期持有，不要每次请求都新建
+func NewClientWithConfig(baseURL, username, password string, config ClientConfig) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	def := DefaultClientConfig()
+	if config.Timeout <= 0 {
+		config.Timeout = def.Timeout
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = def.RetryBackoff
+	}
+	if config.BreakerThreshold <= 0 {
+		config.BreakerThreshold = def.BreakerThreshold
+	}
+	if config.BreakerCooldown <= 0 {
+		config.BreakerCooldown = def.BreakerCooldown
+	}
+	if config.MaxIdleConns <= 0 {
+		config.MaxIdleConns = def.MaxIdleConns
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   2 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          config.MaxIdleConns,
+		MaxIdleConnsPerHost:   config.MaxIdleConns,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: config.Timeout,
+	}
 	return &Client{
-		baseURL:    baseURL,
+		baseURL:    strings.TrimRight(baseURL, "/"),
 		username:   username,
 		password:   password,
-		httpClient: &http.Client{},
+		httpClient: &http.Client{Transport: transport, Timeout: config.Timeout},
+		config:     config,
+		breaker:    newBreaker(config.BreakerThreshold, config.BreakerCooldown),
 	}
 }
 
@@ -118,41 +236,180 @@ func (c *Client) ListIndexes() ([]string, error) {
 // 插入文档
 func (c *Client) InsertDocument(indexName string, document interface{}) error {
 	url := fmt.Sprintf("%s/api/%s/_doc", c.baseURL, indexName)
-	return c.doRequest("POST", url, document, nil)
+	var response struct {
+		ID string `json:"id"`
+	}
+	if err := c.doRequest("POST", url, document, &response); err != nil {
+		return err
+	}
+	if response.ID != "" {
+		c.mirrorWrite(indexName, response.ID, func(index string) error {
+			return c.putDocument(index, response.ID, document)
+		})
+	}
+	return nil
 }
 
 // 更新文档
 func (c *Client) UpdateDocument(indexName, docID string, document interface{}) error {
+	if err := c.putDocument(indexName, docID, document); err != nil {
+		return err
+	}
+	c.mirrorWrite(indexName, docID, func(index string) error {
+		return c.putDocument(index, docID, document)
+	})
+	return nil
+}
+
+func (c *Client) putDocument(indexName, docID string, document interface{}) error {
 	url := fmt.Sprintf("%s/api/%s/_doc/%s", c.baseURL, indexName, docID)
 	return c.doRequest("PUT", url, document, nil)
 }
 
+// 只更新指定字段，其它字段保持不变
+func (c *Client) UpdateFields(indexName, docID string, fields map[string]interface{}) error {
+	if err := c.updateFields(indexName, docID, fields); err != nil {
+		return err
+	}
+	c.mirrorWrite(indexName, docID, func(index string) error {
+		return c.updateFields(index, docID, fields)
+	})
+	return nil
+}
+
+func (c *Client) updateFields(indexName, docID string, fields map[string]interface{}) error {
+	url := fmt.Sprintf("%s/api/%s/_update/%s", c.baseURL, indexName, docID)
+	return c.doRequest("POST", url, fields, nil)
+}
+
 // 删除文档
 func (c *Client) DeleteDocument(indexName, docID string) error {
+	if err := c.deleteDocument(indexName, docID); err != nil {
+		return err
+	}
+	c.mirrorWrite(indexName, docID, func(index string) error {
+		return c.deleteDocument(index, docID)
+	})
+	return nil
+}
+
+func (c *Client) deleteDocument(indexName, docID string) error {
 	url := fmt.Sprintf("%s/api/%s/_doc/%s", c.baseURL, indexName, docID)
 	return c.doRequest("DELETE", url, nil, nil)
 }
 
+// 按id读取文档，不存在时返回nil
+func (c *Client) GetDocument(indexName, docID string) (*Hit, error) {
+	url := fmt.Sprintf("%s/api/%s/_doc/%s", c.baseURL, indexName, docID)
+	var hit Hit
+	if err := c.doRequest("GET", url, nil, &hit); err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &hit, nil
+}
+
+// 按id写入文档，不存在则创建
+func (c *Client) CreateOrUpdate(indexName, docID string, document interface{}) error {
+	return c.UpdateDocument(indexName, docID, document)
+}
+
+// 按id删除文档
+func (c *Client) Delete(indexName, docID string) error {
+	return c.DeleteDocument(indexName, docID)
+}
+
 // 搜索文档
 func (c *Client) Search(indexName string, req *SearchRequest) (*SearchResponse, error) {
+	return c.SearchContext(context.Background(), indexName, req)
+}
+
+// 带context的搜索，调用方可以控制整体超时
+func (c *Client) SearchContext(ctx context.Context, indexName string, req *SearchRequest) (*SearchResponse, error) {
 	url := fmt.Sprintf("%s/api/%s/_search", c.baseURL, indexName)
 	var response SearchResponse
-	err := c.doRequest("POST", url, req, &response)
+	err := c.doRequestContext(ctx, "POST", url, req, &response)
 	return &response, err
 }
 
+// 关键词搜索全部字段，sources为空时返回全部字段
+func (c *Client) SearchTerm(indexName, keyword string, from, size int, sources []string) (*SearchResponse, error) {
+	req := &SearchRequest{
+		SearchType: "match",
+		Query: map[string]interface{}{
+			"term":  keyword,
+			"field": "_all",
+		},
+		From:       from,
+		MaxResults: size,
+		SortFields: []string{"-_score"},
+		Source:     sources,
+	}
+	return c.Search(indexName, req)
+}
+
 // 通用请求处理
 func (c *Client) doRequest(method, url string, body interface{}, result interface{}) error {
-	var reqBody io.Reader
+	return c.doRequestContext(context.Background(), method, url, body, result)
+}
+
+// 熔断打开期间直接失败；读请求和幂等请求在5xx或者连接错误时带抖动重试
+func (c *Client) doRequestContext(ctx context.Context, method, url string, body interface{}, result interface{}) error {
+	var jsonData []byte
 	if body != nil {
-		jsonData, err := json.Marshal(body)
+		var err error
+		jsonData, err = json.Marshal(body)
 		if err != nil {
 			return err
 		}
-		reqBody = bytes.NewBuffer(jsonData)
+	}
+	retries := 0
+	if method != "POST" || strings.HasSuffix(url, "/_search") {
+		retries = c.config.MaxRetries
 	}
 
-	req, err := http.NewRequest(method, url, reqBody)
+	var err error
+	for attempt := 0; ; attempt++ {
+		if !c.breaker.allow() {
+			return ErrCircuitOpen
+		}
+		err = c.do(ctx, method, url, jsonData, result)
+		// 调用方的context先结束时不计入熔断，否则一批很短超时的请求会把正常的服务熔断
+		// 服务卡住时由比调用方超时更短的Timeout先超时，按失败计数
+		if err != nil && ctx.Err() != nil {
+			c.breaker.release()
+			return err
+		}
+		c.breaker.done(!isRetryable(err))
+		if err == nil || !isRetryable(err) || attempt >= retries {
+			return err
+		}
+		backoff := c.config.RetryBackoff << uint(attempt)
+		backoff += time.Duration(rand.Int63n(int64(backoff)))
+		lib.XLogErr("retry", method, url, attempt, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, url string, jsonData []byte, result interface{}) error {
+	var reqBody *bytes.Reader
+	if jsonData != nil {
+		reqBody = bytes.NewReader(jsonData)
+	}
+
+	var req *http.Request
+	var err error
+	if reqBody != nil {
+		req, err = http.NewRequestWithContext(ctx, method, url, reqBody)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, url, nil)
+	}
 	if err != nil {
 		return err
 	}
@@ -169,9 +426,9 @@ func (c *Client) doRequest(method, url string, body interface{}, result interfac
 	if resp.StatusCode >= 300 {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return fmt.Errorf("request failed with status %d", resp.StatusCode)
+			return &StatusError{StatusCode: resp.StatusCode}
 		}
-		return fmt.Errorf("zincsearch error (%d): %s", resp.StatusCode, errResp.Error)
+		return &StatusError{StatusCode: resp.StatusCode, Message: errResp.Error}
 	}
 
 	if result != nil {
@@ -180,3 +437,28 @@ func (c *Client) doRequest(method, url string, body interface{}, result interfac
 
 	return nil
 }
+
+// 文档或索引不存在
+func isNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+// 5xx、超时和连接错误可以重试，4xx和调用方取消的不重试
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}